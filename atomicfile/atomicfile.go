@@ -0,0 +1,48 @@
+// Package atomicfile writes output files so that readers never see them
+// half written.
+package atomicfile
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Write creates filePath through a temporary file in its directory: write
+// fills it, then it is synced and renamed over filePath. On error the
+// temporary file is removed and filePath is left untouched.
+func Write(filePath string, write func(w io.Writer) error) error {
+    dir, name := filepath.Split(filePath)
+    if dir == "" {
+        dir = "."
+    }
+    // The temporary name drops the extension, so readers listing *.csv or
+    // matching "[.]csv" do not pick up a half-written B12345_MV.csv as
+    // .B12345_MV.123.tmp
+    base := strings.TrimSuffix(name, filepath.Ext(name))
+    file, err := os.CreateTemp(dir, "."+base+".*.tmp")
+    if err != nil {
+        return err
+    }
+    tmpPath := file.Name()
+    defer os.Remove(tmpPath) // fails harmlessly once renamed
+
+    if err := write(file); err != nil {
+        file.Close()
+        return fmt.Errorf("writing %s: %w", filePath, err)
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return fmt.Errorf("syncing %s: %w", filePath, err)
+    }
+    if err := file.Close(); err != nil {
+        return fmt.Errorf("closing %s: %w", filePath, err)
+    }
+    // CreateTemp makes the file 0600; give it the usual permissions
+    if err := os.Chmod(tmpPath, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmpPath, filePath)
+}
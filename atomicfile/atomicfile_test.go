@@ -0,0 +1,46 @@
+package atomicfile
+
+import (
+    "io"
+    "os"
+    "path/filepath"
+    "regexp"
+    "testing"
+)
+
+func TestWriteHidesPartialFile(t *testing.T) {
+    dir := t.TempDir()
+    target := filepath.Join(dir, "B12345_MV.csv")
+
+    // The pattern R's update() lists the CSV folder with
+    csvPattern := regexp.MustCompile(`.[.]csv`)
+
+    err := Write(target, func(w io.Writer) error {
+        entries, err := os.ReadDir(dir)
+        if err != nil {
+            return err
+        }
+        if len(entries) != 1 {
+            t.Fatalf("found %d files while writing, want the temporary file only", len(entries))
+        }
+        if name := entries[0].Name(); csvPattern.MatchString(name) {
+            t.Errorf("temporary file %s matches %s", name, csvPattern)
+        }
+        _, err = io.WriteString(w, "Lot\nB12345\n")
+        return err
+    })
+    if err != nil {
+        t.Fatalf("Write: %v", err)
+    }
+
+    data, err := os.ReadFile(target)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != "Lot\nB12345\n" {
+        t.Errorf("wrote %q", data)
+    }
+    if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+        t.Errorf("found %d files after writing, want 1", len(entries))
+    }
+}
@@ -0,0 +1,142 @@
+// Package barcode reads cartridge barcodes and maps their type letter to an
+// instrument platform.
+package barcode
+
+import (
+    "cmp"
+    "fmt"
+    "strings"
+)
+
+const barcodeLength = 11
+
+// instrumentTypes maps the barcode type letter to its instrument platform,
+// as in R's check_if_moved. AddInstrumentTypes extends it.
+var instrumentTypes = map[string]string{
+    "B": "XFe24",
+    "C": "XFp",
+    "W": "XFe96",
+    "X": "XFe96",
+    "Y": "XFe24",
+    "Z": "XFp",
+}
+
+// Barcode is a cartridge barcode: one type letter, a five digit serial
+// number and a five digit lot number.
+type Barcode struct {
+    Raw        string
+    Type       string
+    SN         string // serial number with leading zeros removed
+    SNRaw      string // serial number as printed
+    Lot        string // type letter followed by the lot digits
+    Instrument string
+}
+
+// Parse splits a barcode into its fields. On error the returned
+// Barcode still carries whatever fields could be read.
+func Parse(text string) (Barcode, error) {
+    text = strings.TrimSpace(text)
+    b := Barcode{Raw: text}
+
+    if text == "" {
+        return b, fmt.Errorf("barcode is empty")
+    }
+    b.Type = text[:1]
+
+    if len(text) < barcodeLength {
+        return b, fmt.Errorf("barcode %q has %d characters, want %d", text, len(text), barcodeLength)
+    }
+
+    b.SNRaw = text[1:6]
+    b.SN = strings.TrimLeft(b.SNRaw, "0")
+    b.Lot = b.Type + text[6:11]
+
+    instrument, ok := InstrumentForType(b.Type)
+    if !ok {
+        return b, fmt.Errorf("barcode %q has unknown type letter %q", text, b.Type)
+    }
+    b.Instrument = instrument
+
+    if !isDigits(b.SNRaw) {
+        return b, fmt.Errorf("barcode %q has non-numeric serial number %q", text, b.SNRaw)
+    }
+    if !isDigits(text[6:11]) {
+        return b, fmt.Errorf("barcode %q has non-numeric lot number %q", text, text[6:11])
+    }
+    if len(text) > barcodeLength {
+        return b, fmt.Errorf("barcode %q has %d characters, want %d", text, len(text), barcodeLength)
+    }
+
+    return b, nil
+}
+
+// InstrumentForType returns the instrument platform of a type letter.
+func InstrumentForType(letter string) (string, bool) {
+    instrument, ok := instrumentTypes[strings.ToUpper(letter)]
+    return instrument, ok
+}
+
+// AddInstrumentTypes registers type letters from a spec such as
+// "D=XFe96,E=XFp". Entries override the built-in letters.
+func AddInstrumentTypes(spec string) error {
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        letter, instrument, ok := strings.Cut(entry, "=")
+        letter = strings.ToUpper(strings.TrimSpace(letter))
+        instrument = strings.TrimSpace(instrument)
+        if !ok || len(letter) != 1 || instrument == "" {
+            return fmt.Errorf("invalid instrument type %q, want LETTER=Instrument", entry)
+        }
+        instrumentTypes[letter] = instrument
+    }
+    return nil
+}
+
+// CompareSN orders serial numbers by value, so "99" comes before "100".
+// Serial numbers have their leading zeros removed, so "" is 00000. Those
+// that are not numbers sort after the rest, by text.
+func CompareSN(a, b string) int {
+    numA, numB := a == "" || isDigits(a), b == "" || isDigits(b)
+    switch {
+    case numA && numB:
+        if len(a) != len(b) {
+            return cmp.Compare(len(a), len(b))
+        }
+    case numA:
+        return -1
+    case numB:
+        return 1
+    }
+    return strings.Compare(a, b)
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return s != ""
+}
+
+// Details returns the barcode columns for a row. Invalid barcodes are
+// flagged in the BarcodeError column rather than dropped.
+func Details(text string) map[string]string {
+    b, err := Parse(text)
+
+    barcodeData := map[string]string{
+        "Type":         b.Type,
+        "SN":           b.SN,
+        "Lot":          b.Lot,
+        "Instrument":   b.Instrument,
+        "BarcodeError": "",
+    }
+    if err != nil {
+        barcodeData["BarcodeError"] = err.Error()
+    }
+
+    return barcodeData
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "os/signal"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "strings"
+    "syscall"
+    "time"
+
+    _ "github.com/mattn/go-sqlite3"
+
+    "github.com/JARS3N/Vis/barcode"
+    "github.com/JARS3N/Vis/details"
+    "github.com/JARS3N/Vis/site"
+    "github.com/JARS3N/Vis/store"
+    "github.com/JARS3N/Vis/wrangler"
+)
+
+const (
+    visWrangler = "viswrangler.exe"
+)
+
+// settings is loaded once at startup by site.Load.
+var settings site.Settings
+
+// stageStore marks errors storing a lot's measurements in the database.
+const stageStore = "store"
+
+// lotDirPattern matches lot folder names: a type letter and five digits.
+var lotDirPattern = regexp.MustCompile(`^[A-Z]{1}[0-9]{5}$`)
+
+// Lot states recorded in the status column of `machine-vision`.
+const (
+    lotSuccess = "success" // viswrangler succeeded and wrote rows
+    lotPartial = "partial" // rows were written but some files failed to parse or to be stored
+    lotFailed  = "failed"  // no usable CSV was written
+)
+
+// lotRecord is the tracking row of a lot already seen by the worker.
+type lotRecord struct {
+    Status   string
+    Attempts int
+}
+
+// parseFunc parses a lot folder into its <Lot>_MV.csv. Result.Outputs
+// lists the files the run wrote; out of process it holds only the lot's
+// CSV, when its modification time shows this run wrote it. Result.Checks
+// holds the parsed cartridges, which are stored in the database.
+type parseFunc func(dir string) (wrangler.Result, error)
+
+// mtimeSlack allows for file systems that store modification times to
+// the nearest two seconds, as FAT and some network shares do.
+const mtimeSlack = 2 * time.Second
+
+// errFilesFailed is returned by runInProcess when some files of a lot did
+// not parse; they are listed in the result.
+var errFilesFailed = errors.New("files failed to parse")
+
+// lotRun is a lot folder picked for processing.
+type lotRun struct {
+    Dir      string
+    Attempts int               // attempts already made on these files
+    Files    []store.FileEntry // details.xml files seen before the run
+}
+
+func main() {
+    silentFlag := flag.Bool("silent", true, "Run in silent mode")
+    verboseFlag := flag.Bool("verbose", false, "Run in verbose mode")
+    externalFlag := flag.Bool("external", false, "Run viswrangler.exe instead of parsing in-process")
+    waitFlag := flag.Bool("wait", false, "Wait for a running worker to finish instead of exiting")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: vis_worker [options] [migrate|watch]\n\n")
+        fmt.Fprintf(flag.CommandLine.Output(), "With no command, parse new, changed and retried lots once.\n")
+        fmt.Fprintf(flag.CommandLine.Output(), "migrate only upgrades the database schema.\n")
+        fmt.Fprintf(flag.CommandLine.Output(), "watch keeps running, parsing each lot once its folder has been quiet.\n\n")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    command := flag.Arg(0)
+    if command != "" && command != "migrate" && command != "watch" {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    if *silentFlag && !*verboseFlag {
+        log.SetOutput(os.Stdout) // Change this to os.Stderr if you want silent logs to go to stderr
+        log.SetFlags(0)
+    }
+
+    log.Println("Starting program...")
+
+    var err error
+    settings, err = site.Load()
+    if err != nil {
+        log.Fatalf("Failed to load settings: %v", err)
+    }
+    dbPath := settings.DBPath
+
+    // viswrangler.exe is only needed when parsing out of process
+    var visWranglerPath string
+    if *externalFlag && command != "migrate" {
+        visWranglerPath, err = findVisWrangler()
+        if err != nil {
+            log.Println("viswrangler not found, exiting.")
+            return
+        }
+    }
+
+    // A new site starts without a database; create it next to the CSVs
+    if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+        log.Fatalf("Failed to create directory for SQLite database: %v", err)
+    }
+
+    // Try to open the SQLite database. Another worker may be writing, so
+    // wait for its locks rather than failing with "database is locked".
+    db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=10000")
+    if err != nil {
+        log.Fatalf("Failed to open SQLite database: %v", err)
+    }
+    defer db.Close()
+
+    // Bring the schema up to date, keeping the lot history
+    applied, err := store.Migrate(db)
+    if err != nil {
+        log.Fatalf("Failed to migrate SQLite database: %v", err)
+    }
+    for _, version := range applied {
+        log.Printf("Applied schema migration %d", version)
+    }
+    if command == "migrate" {
+        log.Printf("Database %s is at schema version %d", dbPath, store.LatestVersion())
+        return
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    // Only one worker may process lots at a time
+    lease, err := acquireLease(ctx, db, *waitFlag)
+    if err != nil {
+        if errors.Is(err, store.ErrLeaseHeld) || ctx.Err() != nil {
+            log.Printf("Not starting, %v", err)
+            return
+        }
+        log.Fatalf("Failed to lock SQLite database: %v", err)
+    }
+    defer func() {
+        if err := lease.Release(); err != nil {
+            log.Printf("Failed to release worker lease: %v", err)
+        }
+    }()
+
+    // Stop as for a shutdown signal if another worker takes the lease over
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    go func() {
+        select {
+        case <-lease.Lost():
+            log.Printf("Stopping, the worker lease was lost")
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+
+    runCommand := command
+    if runCommand == "" {
+        runCommand = "run"
+    }
+    run, err := store.StartRun(db, "vis_worker", runCommand, wrangler.Version)
+    if err != nil {
+        log.Printf("Failed to record run: %v", err)
+    }
+    defer func() {
+        if err := run.Finish(); err != nil {
+            log.Printf("Failed to record end of run: %v", err)
+        }
+    }()
+
+    parse := runInProcess
+    if *externalFlag {
+        parse = func(dir string) (wrangler.Result, error) {
+            var result wrangler.Result
+            started := time.Now()
+            err := runVisWrangler(visWranglerPath, dir)
+            csvPath := lotCSVPath(dir)
+            if info, statErr := os.Stat(csvPath); statErr == nil && info.Size() > 0 && !info.ModTime().Before(started.Add(-mtimeSlack)) {
+                result.Outputs = []string{csvPath}
+            }
+
+            // Parse the files again for the database; viswrangler records
+            // the files that failed itself
+            files, findErr := details.GetDetailsXMLFiles(dir)
+            if findErr != nil {
+                log.Printf("Failed to find details.xml files in %s: %v", dir, findErr)
+            }
+            rows, checks, _ := wrangler.ProcessFiles(files, wrangler.DefaultJobs())
+            result.Files = len(files)
+            result.Rows = len(rows)
+            result.Checks = checks
+            return result, err
+        }
+    }
+
+    if command == "watch" {
+        if err := watch(ctx, db, run, parse, *verboseFlag); err != nil {
+            log.Fatalf("Failed to watch platform directories: %v", err)
+        }
+        log.Println("Stopped watching.")
+        return
+    }
+
+    // Get the current directories
+    currentDirs := getCurrentDirs(settings.PlatformDirs)
+
+    //log.Printf("Found %d current directories.\n", len(currentDirs))
+
+    processed, err := processLots(ctx, db, run, currentDirs, parse, *verboseFlag)
+    if err != nil {
+        log.Fatalf("Failed to process lots: %v", err)
+    }
+    if processed == 0 {
+        log.Println("No new directories found, exiting.")
+    }
+}
+
+// processLots picks the lots among currentDirs that need processing and
+// parses them one at a time with parse, recording each outcome and the
+// files that failed under run. It returns how many lots were processed,
+// stopping early once ctx is cancelled.
+func processLots(ctx context.Context, db *sql.DB, run *store.Run, currentDirs []string, parse parseFunc, verbose bool) (int, error) {
+    existingLots, err := getExistingLots(db)
+    if err != nil {
+        return 0, fmt.Errorf("getting existing Lots from SQLite database: %w", err)
+    }
+
+    manifests, err := store.LoadManifests(db)
+    if err != nil {
+        return 0, fmt.Errorf("getting lot manifests from SQLite database: %w", err)
+    }
+
+    // Filter directories to process
+    dirsToProcess := filterDirectories(db, existingLots, manifests, currentDirs, settings.MaxRetries)
+
+    if len(dirsToProcess) == 0 {
+        return 0, nil
+    }
+
+    // Process each directory
+    outcomes := make(map[string]string)
+    processed := 0
+    for _, lotRun := range dirsToProcess {
+        // Finish the lot in hand, but start no more after a shutdown signal
+        if ctx.Err() != nil {
+            log.Printf("Stopping with %d lots left to process", len(dirsToProcess)-processed)
+            break
+        }
+        dir := lotRun.Dir
+        result, runErr := parse(dir)
+        written := lotWritten(dir, result)
+        var storeErr error
+        if len(result.Checks) > 0 {
+            storeErr = saveMeasurements(db, dir, result.Checks)
+        }
+        status := lotOutcome(written, runErr, storeErr)
+        outcomes[dir] = status
+        updateDatabase(db, dir, status, lotRun.Attempts+1)
+        if err := store.SaveManifest(db, filepath.Base(dir), lotRun.Files); err != nil {
+            log.Printf("Failed to save manifest for directory %s: %v", dir, err)
+        }
+
+        // Failures of the run as a whole are recorded against the lot folder
+        errs := wrangler.StoreErrors(result.Errors)
+        if runErr != nil && !errors.Is(runErr, errFilesFailed) {
+            errs = append(errs, store.FileError{Path: dir, Stage: wrangler.StageRun, Err: runErr.Error()})
+        }
+        if storeErr != nil {
+            errs = append(errs, store.FileError{Path: dir, Stage: stageStore, Err: storeErr.Error()})
+        }
+        if err := run.Record(len(lotRun.Files), result.Rows, errs); err != nil {
+            log.Printf("Failed to record errors for directory %s: %v", dir, err)
+        }
+        processed++
+    }
+
+    // Print processed lots if verbose flag is set
+    if verbose {
+        log.Println("Processed Lots:")
+        for _, lotRun := range dirsToProcess[:processed] {
+            log.Printf("%s %s\n", filepath.Base(lotRun.Dir), outcomes[lotRun.Dir])
+        }
+    }
+    return processed, nil
+}
+
+// acquireLease takes the worker lease. With wait it retries until the
+// lease is free or ctx is cancelled.
+func acquireLease(ctx context.Context, db *sql.DB, wait bool) (*store.Lease, error) {
+    for {
+        lease, err := store.AcquireLease(db)
+        if err == nil || !wait || !errors.Is(err, store.ErrLeaseHeld) {
+            return lease, err
+        }
+        log.Printf("Waiting, %v", err)
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        case <-time.After(store.LeaseTTL / 4):
+        }
+    }
+}
+
+func getExistingLots(db *sql.DB) (map[string]lotRecord, error) {
+    rows, err := db.Query("SELECT Lot, status, attempts FROM `machine-vision`")
+    if err != nil {
+        return nil, fmt.Errorf("query error: %w", err)
+    }
+    defer rows.Close()
+
+    existingLots := make(map[string]lotRecord)
+    for rows.Next() {
+        var lot string
+        var record lotRecord
+        if err := rows.Scan(&lot, &record.Status, &record.Attempts); err != nil {
+            return nil, fmt.Errorf("scan error: %w", err)
+        }
+        existingLots[lot] = record
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("rows iteration error: %w", err)
+    }
+
+    return existingLots, nil
+}
+
+func getCurrentDirs(directories []string) []string {
+    var currentDirs []string
+    for _, dir := range directories {
+        files, err := os.ReadDir(dir)
+        if err != nil {
+            log.Printf("Error reading directory %s: %v", dir, err)
+            continue
+        }
+        platform := filepath.Base(dir)
+        for _, file := range files {
+            if file.IsDir() {
+                baseName := file.Name()
+                if lotDirPattern.MatchString(baseName) {
+                    checkPlatform(baseName, platform)
+                    currentDirs = append(currentDirs, filepath.Join(dir, baseName))
+                }
+            }
+        }
+    }
+    return currentDirs
+}
+
+// checkPlatform warns when a lot folder sits under a platform directory
+// that does not match the instrument of its type letter.
+func checkPlatform(lot, platform string) {
+    instrument, ok := barcode.InstrumentForType(lot[:1])
+    if !ok {
+        log.Printf("Lot %s under %s has unknown type letter %s", lot, platform, lot[:1])
+        return
+    }
+    if !strings.EqualFold(instrument, platform) {
+        log.Printf("Lot %s is under %s but its type letter belongs to %s", lot, platform, instrument)
+    }
+}
+
+// filterDirectories returns the lots never seen before, the lots whose
+// details.xml files were added to or changed since they were processed,
+// and the failed or partial lots that have been tried fewer than
+// maxRetries times. A changed lot starts again from its first attempt.
+//
+// Lots processed before manifests were kept get one recorded as they are
+// now, without being reprocessed.
+func filterDirectories(db *sql.DB, existingLots map[string]lotRecord, manifests map[string]store.Manifest, currentDirs []string, maxRetries int) []lotRun {
+    var dirsToProcess []lotRun
+    for _, dir := range currentDirs {
+        lot := filepath.Base(dir)
+        manifest := manifests[lot]
+        files, err := store.ScanLot(dir, manifest)
+        if err != nil {
+            log.Printf("Error scanning directory %s: %v", dir, err)
+            continue
+        }
+
+        record, ok := existingLots[lot]
+        if !ok {
+            dirsToProcess = append(dirsToProcess, lotRun{Dir: dir, Files: files})
+            continue
+        }
+        if manifest == nil {
+            if err := store.SaveManifest(db, lot, files); err != nil {
+                log.Printf("Failed to save manifest for directory %s: %v", dir, err)
+            }
+        } else if manifest.Changed(files) {
+            log.Printf("Reprocessing lot %s: %d details.xml files, %d before", lot, len(files), len(manifest))
+            dirsToProcess = append(dirsToProcess, lotRun{Dir: dir, Files: files})
+            continue
+        }
+
+        if record.Status == lotSuccess {
+            continue
+        }
+        if record.Attempts < maxRetries {
+            log.Printf("Retrying %s lot %s (attempt %d of %d)", record.Status, lot, record.Attempts+1, maxRetries)
+            dirsToProcess = append(dirsToProcess, lotRun{Dir: dir, Attempts: record.Attempts, Files: files})
+        }
+    }
+    return dirsToProcess
+}
+
+// findVisWrangler looks for viswrangler on the PATH, then next to the
+// worker executable.
+func findVisWrangler() (string, error) {
+    // Check if viswrangler is in the PATH
+    visWranglerPath, err := exec.LookPath(visWrangler)
+    if err == nil {
+        return visWranglerPath, nil
+    }
+    log.Println("viswrangler not found in PATH, checking local directory...")
+
+    // Get the directory of the executable
+    exePath, err := os.Executable()
+    if err != nil {
+        return "", fmt.Errorf("failed to get executable path: %w", err)
+    }
+
+    // Check if viswrangler is in the same directory as the executable
+    localPath := filepath.Join(filepath.Dir(exePath), visWrangler)
+    if _, err := os.Stat(localPath); err != nil {
+        return "", err
+    }
+    return localPath, nil
+}
+
+// runInProcess parses a lot folder into the CSV directory with the
+// wrangler package, as "viswrangler <dir> -d -silent" would.
+func runInProcess(dir string) (wrangler.Result, error) {
+    result, err := wrangler.Run(wrangler.Options{
+        SearchDir: dir,
+        OutputDir: settings.CSVDir,
+        Jobs:      wrangler.DefaultJobs(),
+        Schema:    settings.Schema(),
+    })
+    if len(result.UnknownColumns) > 0 {
+        log.Printf("Columns not in the schema in %s: %s", dir, strings.Join(result.UnknownColumns, ", "))
+    }
+
+    failed := 0
+    for _, e := range result.Errors {
+        log.Printf("Error: %v", e)
+        // Invalid barcodes are flagged in the CSV; retrying will not fix them
+        if e.Stage != wrangler.StageBarcode {
+            failed++
+        }
+    }
+    if err != nil {
+        log.Printf("Failed to parse directory %s: %v", dir, err)
+        return result, err
+    }
+    if failed > 0 {
+        return result, fmt.Errorf("%w: %d of %d files in %s", errFilesFailed, failed, result.Files, dir)
+    }
+    return result, nil
+}
+
+func runVisWrangler(visWranglerPath, dir string) error {
+    args := []string{dir, "-d", "-silent"}
+    cmd := exec.Command(visWranglerPath, args...)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+
+    //log.Printf("Running viswrangler on directory: %s\n", dir)
+    err := cmd.Run()
+    if err != nil {
+        log.Printf("Failed to run viswrangler on directory %s: %v", dir, err)
+    } else {
+        //log.Printf("Successfully ran viswrangler on directory: %s\n", dir)
+    }
+    return err
+}
+
+// lotCSVPath returns the <Lot>_MV.csv of a lot folder in the CSV
+// directory.
+func lotCSVPath(dir string) string {
+    return filepath.Join(settings.CSVDir, fmt.Sprintf("%s_MV.csv", filepath.Base(dir)))
+}
+
+// lotWritten reports whether the run wrote rows to the <Lot>_MV.csv of
+// the lot folder. A file left by an earlier attempt does not count.
+func lotWritten(dir string, result wrangler.Result) bool {
+    csvPath := lotCSVPath(dir)
+    if !slices.Contains(result.Outputs, csvPath) {
+        log.Printf("No rows written to %s for directory %s", csvPath, dir)
+        return false
+    }
+    return true
+}
+
+// lotOutcome decides the state of a lot from the viswrangler exit status,
+// whether its <Lot>_MV.csv was written and whether its measurements were
+// stored.
+func lotOutcome(written bool, runErr, storeErr error) string {
+    if !written {
+        return lotFailed
+    }
+    if runErr != nil || storeErr != nil {
+        return lotPartial
+    }
+    return lotSuccess
+}
+
+// saveMeasurements stores the cartridges parsed from a lot folder in the
+// measurement tables, replacing those of an earlier attempt. Cartridges
+// whose barcodes give another lot are stored with the folder too.
+func saveMeasurements(db *sql.DB, dir string, checks []wrangler.CartridgeCheck) error {
+    lot := filepath.Base(dir)
+    instrument, _ := barcode.InstrumentForType(lot[:1])
+    err := store.SaveLot(db, store.Lot{
+        Lot:        lot,
+        Instrument: instrument,
+        Dir:        dir,
+        Date:       time.Now().Format("2006-01-02"),
+    }, wrangler.StoreCartridges(checks))
+    if err != nil {
+        log.Printf("Failed to store measurements for directory %s: %v", dir, err)
+    }
+    return err
+}
+
+// updateDatabase records the outcome of a lot, replacing the row left by
+// an earlier attempt.
+func updateDatabase(db *sql.DB, dir, status string, attempts int) {
+    lot := filepath.Base(dir)
+    resultCSV := fmt.Sprintf("%s_MV.csv", lot)
+    resultDate := time.Now().Format("2006-01-02")
+
+    //log.Printf("Updating database for directory: %s\n", dir)
+    res, err := db.Exec("UPDATE `machine-vision` SET dir = ?, result_csv = ?, result_date = ?, status = ?, attempts = ? WHERE Lot = ?",
+        dir, resultCSV, resultDate, status, attempts, lot)
+    if err == nil {
+        if n, _ := res.RowsAffected(); n == 0 {
+            _, err = db.Exec("INSERT INTO `machine-vision` (dir, Lot, result_csv, result_date, status, attempts) VALUES (?, ?, ?, ?, ?, ?)",
+                dir, lot, resultCSV, resultDate, status, attempts)
+        }
+    }
+    if err != nil {
+        log.Printf("Failed to update SQLite database for directory %s: %v", dir, err)
+    } else {
+        //log.Printf("Database updated for directory: %s\n", dir)
+    }
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io/fs"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/JARS3N/Vis/store"
+)
+
+// watch runs until ctx is cancelled. It processes what arrived while the
+// worker was stopped, then processes each lot folder once it has been
+// quiet for settings.QuietPeriod. Activity is seen through filesystem
+// notifications where they work, and by rescanning the platform
+// directories every settings.PollInterval, which catches the changes
+// network shares do not report.
+func watch(ctx context.Context, db *sql.DB, run *store.Run, parse parseFunc, verbose bool) error {
+    if _, err := processLots(ctx, db, run, getCurrentDirs(settings.PlatformDirs), parse, verbose); err != nil {
+        return err
+    }
+
+    var events <-chan fsnotify.Event
+    var watchErrs <-chan error
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        log.Printf("File notifications unavailable, polling every %v: %v", settings.PollInterval, err)
+    } else {
+        defer watcher.Close()
+        for _, dir := range settings.PlatformDirs {
+            addWatches(watcher, dir)
+        }
+        events = watcher.Events
+        watchErrs = watcher.Errors
+    }
+
+    // Remember how each lot looks now, so the first poll only reports
+    // lots that changed after the catch-up
+    signatures := make(map[string]string)
+    for _, dir := range listLotDirs(settings.PlatformDirs) {
+        signatures[dir] = lotSignature(dir)
+    }
+
+    pending := make(map[string]time.Time) // lot folder -> last activity
+    pollTicker := time.NewTicker(settings.PollInterval)
+    defer pollTicker.Stop()
+    checkTicker := time.NewTicker(checkInterval(settings.QuietPeriod))
+    defer checkTicker.Stop()
+
+    log.Printf("Watching %s, processing lots after %v of quiet", strings.Join(settings.PlatformDirs, ", "), settings.QuietPeriod)
+    for {
+        select {
+        case <-ctx.Done():
+            if len(pending) > 0 {
+                log.Printf("Stopping with %d lots still settling; they will be picked up on the next run", len(pending))
+            }
+            return nil
+
+        case event, ok := <-events:
+            if !ok {
+                events = nil
+                continue
+            }
+            if dir := lotDirOf(event.Name); dir != "" {
+                pending[dir] = time.Now()
+            }
+            if event.Has(fsnotify.Create) {
+                if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+                    addWatches(watcher, event.Name)
+                }
+            }
+
+        case err, ok := <-watchErrs:
+            if !ok {
+                watchErrs = nil
+                continue
+            }
+            // An overflow drops events; the next poll makes up for them
+            log.Printf("File notification error: %v", err)
+
+        case <-pollTicker.C:
+            for _, dir := range listLotDirs(settings.PlatformDirs) {
+                signature := lotSignature(dir)
+                if signatures[dir] != signature {
+                    signatures[dir] = signature
+                    pending[dir] = time.Now()
+                }
+            }
+
+        case now := <-checkTicker.C:
+            var due []string
+            for dir, last := range pending {
+                if now.Sub(last) >= settings.QuietPeriod {
+                    due = append(due, dir)
+                    delete(pending, dir)
+                }
+            }
+            if len(due) == 0 {
+                continue
+            }
+            if _, err := processLots(ctx, db, run, due, parse, verbose); err != nil {
+                return err
+            }
+            // Our own runs do not count as activity
+            for _, dir := range due {
+                signatures[dir] = lotSignature(dir)
+            }
+        }
+    }
+}
+
+// checkInterval is how often pending lots are checked for quiet: often
+// enough to process a lot soon after its quiet period ends.
+func checkInterval(quiet time.Duration) time.Duration {
+    interval := quiet / 4
+    if interval < time.Second {
+        interval = time.Second
+    }
+    if interval > 15*time.Second {
+        interval = 15 * time.Second
+    }
+    return interval
+}
+
+// addWatches watches root and every directory below it, since fsnotify
+// does not watch recursively.
+func addWatches(watcher *fsnotify.Watcher, root string) {
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() {
+            return watcher.Add(path)
+        }
+        return nil
+    })
+    if err != nil {
+        log.Printf("Cannot watch %s, relying on polling: %v", root, err)
+    }
+}
+
+// listLotDirs returns the lot folders under the platform directories. Unlike
+// getCurrentDirs it does not log, as it runs on every poll.
+func listLotDirs(platformDirs []string) []string {
+    var dirs []string
+    for _, platform := range platformDirs {
+        entries, err := os.ReadDir(platform)
+        if err != nil {
+            continue
+        }
+        for _, entry := range entries {
+            if entry.IsDir() && lotDirPattern.MatchString(entry.Name()) {
+                dirs = append(dirs, filepath.Join(platform, entry.Name()))
+            }
+        }
+    }
+    return dirs
+}
+
+// lotDirOf returns the lot folder a path belongs to, or "" when it is not
+// inside one.
+func lotDirOf(path string) string {
+    for _, platform := range settings.PlatformDirs {
+        rel, err := filepath.Rel(platform, path)
+        if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+            continue
+        }
+        lot := strings.Split(filepath.ToSlash(rel), "/")[0]
+        if lotDirPattern.MatchString(lot) {
+            return filepath.Join(platform, lot)
+        }
+    }
+    return ""
+}
+
+// lotSignature summarizes the files of a lot folder by count, total size
+// and latest modification time; any write to the folder changes it.
+func lotSignature(dir string) string {
+    var count, size int64
+    var latest time.Time
+    filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil || d.IsDir() {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return nil
+        }
+        count++
+        size += info.Size()
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+        return nil
+    })
+    return fmt.Sprintf("%d/%d/%d", count, size, latest.UnixNano())
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/JARS3N/Vis/details"
+    "github.com/JARS3N/Vis/wrangler"
+)
+
+// commands maps each subcommand to its one-line description.
+var commands = map[string]string{
+    "parse":    "Write <Lot>_MV.csv files for a search directory",
+    "ingest":   "Parse into the default CSV directory, as vis_worker does",
+    "scan":     "List the details.xml and context.xml files found, without parsing",
+    "validate": "Check barcodes and wells without writing any output",
+    "report":   "Show what the tracking database recorded: report errors",
+    "version":  "Show version information",
+    "schema":   "Print the JSON Schema of the jsonl and json formats",
+}
+
+func printCommands() {
+    names := make([]string, 0, len(commands))
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Printf("  %-9s%s\n", name, commands[name])
+    }
+}
+
+// parseCommand reads the flags of a subcommand. Flags may come before or
+// after the origin directory.
+func parseCommand(name string, args []string) Config {
+    config := Config{Command: name}
+    if name == "version" || name == "schema" {
+        return config
+    }
+    if name == "report" {
+        return parseReport(args)
+    }
+
+    fs := flag.NewFlagSet("viswrangler "+name, flag.ExitOnError)
+    fs.BoolVar(&config.SilentFlag, "silent", false, "Suppress output")
+    fs.BoolVar(&config.VerboseFlag, "verbose", false, "Report each file as it is processed")
+
+    var origin, current, defaultOut bool
+    var out string
+    if name == "parse" || name == "validate" || name == "ingest" {
+        fs.IntVar(&config.Jobs, "jobs", wrangler.DefaultJobs(), "Number of files processed at once")
+    }
+    if name == "parse" || name == "ingest" {
+        fs.BoolVar(&config.ContextFlag, "context", false, "Also write <Lot>_context.csv from context.xml files")
+        fs.BoolVar(&config.JoinFlag, "join", false, "Add context.xml Result and ResultCodes to each well row")
+        fs.StringVar(&config.Format, "format", wrangler.FormatCSV, "Output format: csv, parquet, jsonl (one cartridge per line), json (one document per lot) or long (one row per measurement)")
+        fs.StringVar(&config.Layout, "layout", wrangler.LayoutPerLot, "Output layout: per-lot (one file per lot), combined (one file for every lot) or partitioned (an Instrument=<I>/Lot=<L>/ dataset)")
+        fs.StringVar(&config.Name, "name", wrangler.DefaultName, "Output file name, without extension; {Lot}, {Instrument} and {Date} are replaced")
+    }
+    if name == "parse" {
+        fs.BoolVar(&origin, "o", false, "Output directory same as origin directory (default)")
+        fs.BoolVar(&current, "c", false, "Output directory as current directory")
+        fs.BoolVar(&defaultOut, "d", false, "Output directory as default directory ("+settings.CSVDir+")")
+        fs.StringVar(&out, "out", "", "Write output to this directory")
+    }
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: viswrangler %s [flags] <origin_directory>\n%s.\n\nFlags:\n", name, commands[name])
+        fs.PrintDefaults()
+    }
+
+    positional := parseInterspersed(fs, args)
+    if len(positional) != 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+    config.SearchDir = positional[0]
+
+    if config.Jobs < 1 && (name == "parse" || name == "validate" || name == "ingest") {
+        log.Fatalf("-jobs must be at least 1, got %d", config.Jobs)
+    }
+
+    switch config.Format {
+    case "", wrangler.FormatCSV, wrangler.FormatParquet, wrangler.FormatJSONL, wrangler.FormatJSON, wrangler.FormatLong:
+    default:
+        log.Fatalf("-format must be csv, parquet, jsonl, json or long, got '%s'", config.Format)
+    }
+
+    switch config.Layout {
+    case "", wrangler.LayoutPerLot, wrangler.LayoutCombined, wrangler.LayoutPartitioned:
+    default:
+        log.Fatalf("-layout must be per-lot, combined or partitioned, got '%s'", config.Layout)
+    }
+    if err := wrangler.CheckName(config.Name); err != nil {
+        log.Fatalf("-name: %v", err)
+    }
+
+    if _, err := os.Stat(config.SearchDir); os.IsNotExist(err) {
+        log.Fatalf("Search directory '%s' does not exist.", config.SearchDir)
+    }
+
+    switch name {
+    case "ingest":
+        config.OutputDir = settings.CSVDir
+    case "parse":
+        selected := 0
+        for _, set := range []bool{origin, current, defaultOut, out != ""} {
+            if set {
+                selected++
+            }
+        }
+        if selected > 1 {
+            log.Fatalf("Only one of -o, -c, -d and -out may be given.")
+        }
+
+        switch {
+        case current:
+            workingDir, err := os.Getwd()
+            if err != nil {
+                log.Fatalf("Failed to get working directory: %v", err)
+            }
+            config.OutputDir = workingDir
+        case defaultOut:
+            config.OutputDir = settings.CSVDir
+        case out != "":
+            config.OutputDir = out
+        default:
+            config.OutputDir = config.SearchDir
+        }
+    default:
+        return config
+    }
+
+    if err := os.MkdirAll(config.OutputDir, os.ModePerm); err != nil {
+        log.Fatalf("Failed to create output directory: %v", err)
+    }
+
+    return config
+}
+
+// parseReport reads "report errors [-n N]".
+func parseReport(args []string) Config {
+    config := Config{Command: "report"}
+    fs := flag.NewFlagSet("viswrangler report", flag.ExitOnError)
+    fs.BoolVar(&config.SilentFlag, "silent", false, "Print only the report lines")
+    fs.IntVar(&config.Limit, "n", 50, "Number of entries to show, newest first")
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: viswrangler report [flags] errors\n%s.\n\nFlags:\n", commands["report"])
+        fs.PrintDefaults()
+    }
+
+    positional := parseInterspersed(fs, args)
+    if len(positional) != 1 || positional[0] != "errors" {
+        fs.Usage()
+        os.Exit(2)
+    }
+    config.Report = positional[0]
+    if config.Limit < 1 {
+        log.Fatalf("-n must be at least 1, got %d", config.Limit)
+    }
+    return config
+}
+
+// parseInterspersed parses flags that may be mixed with positional
+// arguments, which the flag package alone stops at.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+    var positional []string
+    for {
+        fs.Parse(args)
+        args = fs.Args()
+        if len(args) == 0 {
+            return positional
+        }
+        positional = append(positional, args[0])
+        args = args[1:]
+    }
+}
+
+// runScan reports how many inspection files sit in each folder directly
+// under the search directory.
+func runScan(config Config) {
+    detailsFiles, err := details.GetDetailsXMLFiles(config.SearchDir)
+    if err != nil {
+        log.Fatalf("Failed to get details.xml files: %v", err)
+    }
+    contextFiles, err := details.GetContextXMLFiles(config.SearchDir)
+    if err != nil {
+        log.Fatalf("Failed to get context.xml files: %v", err)
+    }
+
+    type counts struct{ details, context int }
+    folders := make(map[string]*counts)
+    folderOf := func(file string) *counts {
+        folder := "."
+        if rel, err := filepath.Rel(config.SearchDir, file); err == nil {
+            if first, _, found := strings.Cut(filepath.ToSlash(rel), "/"); found {
+                folder = first
+            }
+        }
+        if folders[folder] == nil {
+            folders[folder] = &counts{}
+        }
+        return folders[folder]
+    }
+
+    for _, file := range detailsFiles {
+        folderOf(file).details++
+        if config.VerboseFlag {
+            fmt.Println(file)
+        }
+    }
+    for _, file := range contextFiles {
+        folderOf(file).context++
+        if config.VerboseFlag {
+            fmt.Println(file)
+        }
+    }
+
+    if config.SilentFlag {
+        return
+    }
+
+    names := make([]string, 0, len(folders))
+    for name := range folders {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Printf("%s: %d details.xml, %d context.xml\n", name, folders[name].details, folders[name].context)
+    }
+    fmt.Printf("Found %d details.xml and %d context.xml files\n", len(detailsFiles), len(contextFiles))
+}
+
+// runValidate parses every details.xml file and reports invalid barcodes and
+// well problems. It returns false when any file failed to parse or any
+// cartridge failed a check.
+func runValidate(config Config) bool {
+    detailsFiles, err := details.GetDetailsXMLFiles(config.SearchDir)
+    if err != nil {
+        log.Fatalf("Failed to get details.xml files: %v", err)
+    }
+
+    run, closeRun := startRun(config)
+    defer closeRun()
+
+    rows, checks, errs := wrangler.ProcessFiles(detailsFiles, config.Jobs)
+    if err := run.Record(len(detailsFiles), len(rows), wrangler.StoreErrors(errs)); err != nil {
+        log.Printf("Failed to record errors: %v", err)
+    }
+    // Files that failed to parse have no check of their own
+    failed := 0
+    for _, e := range errs {
+        if e.Stage != wrangler.StageBarcode {
+            log.Printf("Error: %v", e)
+            failed++
+        }
+    }
+
+    invalid := 0
+    ok := failed == 0
+    for _, c := range checks {
+        if c.BarcodeError != "" {
+            invalid++
+            ok = false
+        }
+        if !c.Check.OK() {
+            ok = false
+        }
+    }
+
+    if !config.SilentFlag {
+        fmt.Printf("Failed to parse: %d of %d files\n", failed, len(detailsFiles))
+        fmt.Printf("Invalid barcodes: %d of %d cartridges\n", invalid, len(checks))
+        printWellSummary(checks)
+    }
+    return ok
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "os"
+
+    _ "github.com/mattn/go-sqlite3"
+
+    "github.com/JARS3N/Vis/store"
+    "github.com/JARS3N/Vis/wrangler"
+)
+
+// openDB opens the tracking database that vis_worker keeps next to the
+// CSVs, bringing its schema up to date. It fails when there is none.
+func openDB() (*sql.DB, error) {
+    if _, err := os.Stat(settings.DBPath); err != nil {
+        return nil, err
+    }
+    db, err := sql.Open("sqlite3", settings.DBPath+"?_busy_timeout=10000")
+    if err != nil {
+        return nil, err
+    }
+    if _, err := store.Migrate(db); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return db, nil
+}
+
+// startRun records the run in the tracking database. Runs are still made
+// without one, say on a laptop away from the share; it returns a nil Run
+// and a no-op close then.
+func startRun(config Config) (*store.Run, func()) {
+    db, err := openDB()
+    if err != nil {
+        if config.VerboseFlag {
+            log.Printf("Not recording run history: %v", err)
+        }
+        return nil, func() {}
+    }
+    run, err := store.StartRun(db, "viswrangler", config.Command, wrangler.Version)
+    if err != nil {
+        log.Printf("Failed to record run: %v", err)
+    }
+    return run, func() {
+        if err := run.Finish(); err != nil {
+            log.Printf("Failed to record end of run: %v", err)
+        }
+        db.Close()
+    }
+}
+
+// runReport prints what the tracking database holds about past runs.
+func runReport(config Config) {
+    db, err := openDB()
+    if err != nil {
+        log.Fatalf("Failed to open SQLite database %s: %v", settings.DBPath, err)
+    }
+    defer db.Close()
+
+    switch config.Report {
+    case "errors":
+        records, err := store.RecentErrors(db, config.Limit)
+        if err != nil {
+            log.Fatalf("Failed to read errors: %v", err)
+        }
+        for _, r := range records {
+            fmt.Printf("%s %s@%s %s (%s): %s\n", r.Occurred, r.Program, r.Host, r.Path, r.Stage, r.Err)
+        }
+        if !config.SilentFlag {
+            fmt.Printf("%d errors shown\n", len(records))
+        }
+    }
+}
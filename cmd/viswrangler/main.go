@@ -0,0 +1,290 @@
+// Version 2.2
+
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/JARS3N/Vis/barcode"
+    "github.com/JARS3N/Vis/details"
+    "github.com/JARS3N/Vis/jsonout"
+    "github.com/JARS3N/Vis/site"
+    "github.com/JARS3N/Vis/store"
+    "github.com/JARS3N/Vis/wrangler"
+)
+
+const (
+    currentVersion = wrangler.Version
+    isoDate        = wrangler.VersionDate
+)
+
+// settings is loaded once at startup by site.Load.
+var settings site.Settings
+
+type Config struct {
+    Command     string
+    SearchDir   string
+    OutputDir   string
+    SilentFlag  bool
+    VerboseFlag bool
+    ContextFlag bool
+    JoinFlag    bool
+    Jobs        int
+    Format      string // output format of the well rows
+    Layout      string // per-lot, combined or partitioned
+    Name        string // output file name template
+    Report      string // what the report command shows
+    Limit       int    // how many report lines
+}
+
+func ParseFlags() Config {
+    if len(os.Args) > 1 {
+        if _, ok := commands[os.Args[1]]; ok {
+            return parseCommand(os.Args[1], os.Args[2:])
+        }
+    }
+    return parseLegacyFlags()
+}
+
+// parseLegacyFlags handles the original "viswrangler <dir> [output_option]"
+// invocation, which runs the parse command.
+func parseLegacyFlags() Config {
+    // Define command-line flags
+    helpFlag := flag.Bool("help", false, "Show usage information")
+    jobsFlag := flag.Int("jobs", wrangler.DefaultJobs(), "Number of files processed at once")
+    flag.Parse()
+
+    args := flag.Args()
+    if len(args) < 1 || *helpFlag {
+        fmt.Printf("Vision Wrangler | version %s | %s\n", currentVersion, isoDate)
+        fmt.Println("Usage: viswrangler [origin_directory] [output_option] [-silent] [-context] [-join] [-jobs N]")
+        fmt.Println("Options:")
+        fmt.Println("  -o       Output directory same as origin directory")
+        fmt.Println("  -c       Output directory as current directory")
+        fmt.Printf("  -d       Output directory as default directory (%s)\n", settings.CSVDir)
+        fmt.Println("  <path>   Specify a specific output directory")
+        fmt.Println("  -silent  Suppress output")
+        fmt.Println("  -context Also write <Lot>_context.csv from context.xml files")
+        fmt.Println("  -join    Add context.xml Result and ResultCodes to each well row")
+        fmt.Printf("  -jobs N  Number of files processed at once (default %d)\n", wrangler.DefaultJobs())
+        fmt.Println("  -help    Show usage information.")
+        fmt.Println("\nCommands:")
+        printCommands()
+        fmt.Println("\nNote: Paths must be enclosed in double quotes.")
+        os.Exit(0)
+    }
+
+    var searchDir, outputDir string
+    silentFlag := false
+    contextFlag := false
+    joinFlag := false
+    jobs := *jobsFlag
+
+    var positional []string
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        switch {
+        case arg == "-silent":
+            silentFlag = true
+        case arg == "-context":
+            contextFlag = true
+        case arg == "-join":
+            joinFlag = true
+        case arg == "-jobs" && i+1 < len(args):
+            i++
+            jobs = parseJobs(args[i])
+        case strings.HasPrefix(arg, "-jobs="):
+            jobs = parseJobs(strings.TrimPrefix(arg, "-jobs="))
+        default:
+            positional = append(positional, arg)
+        }
+    }
+    if jobs < 1 {
+        log.Fatalf("-jobs must be at least 1, got %d", jobs)
+    }
+    args = positional
+    if len(args) < 1 {
+        log.Fatalf("No origin directory given.")
+    }
+
+    workingDir, err := os.Getwd()
+    if err != nil {
+        log.Fatalf("Failed to get working directory: %v", err)
+    }
+
+    searchDir = args[0]
+
+    if len(args) > 1 {
+        secondArg := args[1]
+        switch secondArg {
+        case "-o":
+            outputDir = searchDir
+        case "-c":
+            outputDir = workingDir
+        case "-d":
+            outputDir = settings.CSVDir
+        default:
+            outputDir = secondArg
+        }
+    } else {
+        outputDir = searchDir
+    }
+
+    if _, err := os.Stat(searchDir); os.IsNotExist(err) {
+        log.Fatalf("Search directory '%s' does not exist.", searchDir)
+    }
+
+    err = os.MkdirAll(outputDir, os.ModePerm)
+    if err != nil {
+        log.Fatalf("Failed to create output directory: %v", err)
+    }
+
+    return Config{
+        Command:     "parse",
+        SearchDir:   searchDir,
+        OutputDir:   outputDir,
+        SilentFlag:  silentFlag,
+        ContextFlag: contextFlag,
+        JoinFlag:    joinFlag,
+        Jobs:        jobs,
+    }
+}
+
+func parseJobs(value string) int {
+    jobs, err := strconv.Atoi(value)
+    if err != nil {
+        log.Fatalf("Invalid -jobs value '%s': %v", value, err)
+    }
+    return jobs
+}
+
+func main() {
+    var err error
+    settings, err = site.Load()
+    if err != nil {
+        log.Fatalf("Failed to load settings: %v", err)
+    }
+
+    config := ParseFlags()
+
+    switch config.Command {
+    case "version":
+        fmt.Printf("Vision Wrangler | version %s | %s\n", currentVersion, isoDate)
+    case "schema":
+        os.Stdout.Write(jsonout.Schema)
+    case "scan":
+        runScan(config)
+    case "report":
+        runReport(config)
+    case "validate":
+        if !runValidate(config) {
+            os.Exit(1)
+        }
+    default:
+        runParse(config)
+    }
+}
+
+// runParse writes one <Lot>_MV.csv per lot found under the search directory.
+func runParse(config Config) {
+    start := time.Now() // Start timing
+
+    // Get all details.xml files in the search directory
+    detailsFiles, err := details.GetDetailsXMLFiles(config.SearchDir)
+    if err != nil {
+        log.Fatalf("Failed to get details.xml files: %v", err)
+    }
+
+    if !config.SilentFlag {
+        fmt.Printf("Processing %d files...\n", len(detailsFiles))
+    }
+
+    run, closeRun := startRun(config)
+    defer closeRun()
+
+    result, err := wrangler.Run(wrangler.Options{
+        SearchDir: config.SearchDir,
+        OutputDir: config.OutputDir,
+        Jobs:      config.Jobs,
+        Context:   config.ContextFlag,
+        Join:      config.JoinFlag,
+        Files:     detailsFiles,
+        Format:    config.Format,
+        Layout:    config.Layout,
+        Name:      config.Name,
+        Schema:    settings.Schema(),
+    })
+    logResult(config, result)
+    errs := wrangler.StoreErrors(result.Errors)
+    if err != nil {
+        errs = append(errs, store.FileError{Path: config.SearchDir, Stage: wrangler.StageRun, Err: err.Error()})
+    }
+    if err := run.Record(result.Files, result.Rows, errs); err != nil {
+        log.Printf("Failed to record errors: %v", err)
+    }
+    if err != nil {
+        closeRun()
+        log.Fatalf("Error: %v", err)
+    }
+
+    if !config.SilentFlag {
+        for _, output := range result.Outputs {
+            fmt.Printf("File created successfully at %s\n", output)
+        }
+        printWellSummary(result.Checks)
+    }
+
+    end := time.Now() // End timing
+    duration := end.Sub(start)
+
+    if !config.SilentFlag {
+        fmt.Printf("Processed %d files in %v\n", len(detailsFiles), duration)
+    }
+}
+
+// logResult reports the files that failed, the cartridges missing from
+// one side of the context join and the columns the schema does not name.
+func logResult(config Config, result wrangler.Result) {
+    for _, e := range result.Errors {
+        log.Printf("Error: %v", e)
+    }
+    for _, key := range result.MissingContext {
+        log.Printf("Cartridge Lot %s SN %s has details.xml but no context.xml", key.Lot, key.SN)
+    }
+    for _, key := range result.MissingDetails {
+        log.Printf("Cartridge Lot %s SN %s has context.xml but no details.xml", key.Lot, key.SN)
+    }
+    if len(result.UnknownColumns) > 0 {
+        log.Printf("Columns not in the schema: %s", strings.Join(result.UnknownColumns, ", "))
+    }
+    if config.VerboseFlag {
+        for _, c := range result.Checks {
+            log.Printf("Parsed %s: %d wells", c.File, c.Wells)
+        }
+    }
+}
+
+func printWellSummary(checks []wrangler.CartridgeCheck) {
+    sort.Slice(checks, func(i, j int) bool {
+        if checks[i].Lot != checks[j].Lot {
+            return checks[i].Lot < checks[j].Lot
+        }
+        return barcode.CompareSN(checks[i].SN, checks[j].SN) < 0
+    })
+
+    failed := 0
+    for _, c := range checks {
+        if !c.Check.OK() {
+            failed++
+            fmt.Printf("Lot %s SN %s (%s): %s\n", c.Lot, c.SN, c.File, c.Check)
+        }
+    }
+    fmt.Printf("Well validation: %d of %d cartridges ok\n", len(checks)-failed, len(checks))
+}
@@ -0,0 +1,127 @@
+// Package csvout writes tables as the CSV files read by the R scripts.
+package csvout
+
+import (
+    "encoding/csv"
+    "io"
+    "sort"
+    "strings"
+
+    "github.com/JARS3N/Vis/atomicfile"
+)
+
+// Write writes the rows to filePath with every key of every row as a
+// column, ordered by Headers.
+func Write(filePath string, data []map[string]string) error {
+    headers, rows, _ := Schema{}.Apply(data)
+    return WriteColumns(filePath, headers, rows)
+}
+
+// WriteColumns writes the rows to filePath under the given header.
+//
+// The rows go to a temporary file in the same directory that is renamed
+// over filePath once complete, so readers never see a partial CSV.
+func WriteColumns(filePath string, headers []string, data []map[string]string) error {
+    return atomicfile.Write(filePath, func(w io.Writer) error {
+        return writeRows(w, headers, data)
+    })
+}
+
+func writeRows(w io.Writer, orderedHeaders []string, data []map[string]string) error {
+    writer := csv.NewWriter(w)
+
+    if len(data) == 0 {
+        return nil
+    }
+
+    if err := writer.Write(orderedHeaders); err != nil {
+        return err
+    }
+
+    // Write data
+    for _, row := range data {
+        var record []string
+        for _, header := range orderedHeaders {
+            record = append(record, row[header])
+        }
+        if err := writer.Write(record); err != nil {
+            return err
+        }
+    }
+
+    writer.Flush()
+    return writer.Error()
+}
+
+// Headers orders the columns of a row: barcode and other general columns
+// first, then the Optical_, Spot_ and port columns, then Well.
+func Headers(row map[string]string) []string {
+    var headers []string
+    orderedHeaders := []string{}
+    opticalHeaders := []string{}
+    spotHeaders := []string{}
+    portHeaders := []string{}
+    wellHeader := "Well"
+
+    for k := range row {
+        if strings.HasPrefix(k, "Optical_") {
+            opticalHeaders = append(opticalHeaders, k)
+        } else if strings.HasPrefix(k, "Spot_") {
+            spotHeaders = append(spotHeaders, k)
+        } else if strings.HasPrefix(k, "Port_") || strings.HasPrefix(k, "Drug_") {
+            portHeaders = append(portHeaders, k)
+        } else if k != "Well" {
+            headers = append(headers, k)
+        }
+    }
+
+    sort.Strings(headers)
+    sort.Strings(opticalHeaders)
+    sort.Strings(spotHeaders)
+    sort.Strings(portHeaders)
+
+    orderedHeaders = append(orderedHeaders, headers...)
+    orderedHeaders = append(orderedHeaders, opticalHeaders...)
+    orderedHeaders = append(orderedHeaders, spotHeaders...)
+    orderedHeaders = append(orderedHeaders, portHeaders...)
+    if _, ok := row[wellHeader]; ok {
+        orderedHeaders = append(orderedHeaders, wellHeader)
+    }
+    return orderedHeaders
+}
+
+// LongHeader is the header of the long format. It is the same for every
+// lot, so long files can be appended into one dataset.
+var LongHeader = []string{"Lot", "SN", "Type", "Well", "Section", "Metric", "Value", "Unit", "SourceFile"}
+
+// LongRow is one measurement of the long format.
+type LongRow struct {
+    Lot        string
+    SN         string
+    Type       string
+    Well       string
+    Section    string // Optical, Spot, Port or Drug
+    Metric     string
+    Value      string
+    Unit       string
+    SourceFile string
+}
+
+// WriteLong writes one row per measurement to filePath, atomically like
+// Write.
+func WriteLong(filePath string, rows []LongRow) error {
+    return atomicfile.Write(filePath, func(w io.Writer) error {
+        writer := csv.NewWriter(w)
+        if err := writer.Write(LongHeader); err != nil {
+            return err
+        }
+        for _, r := range rows {
+            record := []string{r.Lot, r.SN, r.Type, r.Well, r.Section, r.Metric, r.Value, r.Unit, r.SourceFile}
+            if err := writer.Write(record); err != nil {
+                return err
+            }
+        }
+        writer.Flush()
+        return writer.Error()
+    })
+}
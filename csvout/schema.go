@@ -0,0 +1,123 @@
+package csvout
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Policies for keys that a Schema does not name.
+const (
+    ExtrasReport = "report" // leave them out and return them to be reported
+    ExtrasColumn = "extras" // fold them into the Extras column as key=value pairs
+)
+
+// ExtrasHeader is the column that holds unknown keys under ExtrasColumn.
+const ExtrasHeader = "Extras"
+
+// Schema fixes the columns of a wide table. Each entry of Columns is a
+// column name, always written, or a prefix ending in * that stands for
+// every key with that prefix found in the rows, sorted. A Schema with no
+// Columns writes every key found, ordered as Headers orders them.
+type Schema struct {
+    Columns []string
+    Extras  string
+}
+
+// DefaultSchema is the built-in layout of <Lot>_MV.csv: the barcode and
+// validation columns, the measurements by section, then Well.
+var DefaultSchema = Schema{
+    Columns: []string{
+        "BarcodeError", "Instrument", "Lot", "SN", "Type", "WellValidation",
+        "Optical_*", "Spot_*", "Drug_*", "Port_*", "Well",
+    },
+    Extras: ExtrasReport,
+}
+
+// Validate checks the extras policy and the column entries.
+func (s Schema) Validate() error {
+    switch s.Extras {
+    case "", ExtrasReport, ExtrasColumn:
+    default:
+        return fmt.Errorf("unknown extras policy %q, want %q or %q", s.Extras, ExtrasReport, ExtrasColumn)
+    }
+    for _, c := range s.Columns {
+        if c == "" || c == "*" || strings.Contains(strings.TrimSuffix(c, "*"), "*") {
+            return fmt.Errorf("invalid column %q, want a name or a prefix ending in *", c)
+        }
+    }
+    return nil
+}
+
+// Apply lays out the rows by the schema, taking the keys of every row
+// into account. It returns the header, the rows (copied, with the Extras
+// column filled in under ExtrasColumn) and the keys the schema does not
+// name, sorted.
+func (s Schema) Apply(data []map[string]string) ([]string, []map[string]string, []string) {
+    keys := make(map[string]bool)
+    for _, row := range data {
+        for k := range row {
+            keys[k] = true
+        }
+    }
+
+    if len(s.Columns) == 0 {
+        union := make(map[string]string, len(keys))
+        for k := range keys {
+            union[k] = ""
+        }
+        return Headers(union), data, nil
+    }
+
+    var headers []string
+    named := make(map[string]bool)
+    for _, c := range s.Columns {
+        if prefix, ok := strings.CutSuffix(c, "*"); ok {
+            var matched []string
+            for k := range keys {
+                if strings.HasPrefix(k, prefix) && !named[k] {
+                    matched = append(matched, k)
+                }
+            }
+            sort.Strings(matched)
+            for _, k := range matched {
+                named[k] = true
+            }
+            headers = append(headers, matched...)
+        } else if !named[c] {
+            named[c] = true
+            headers = append(headers, c)
+        }
+    }
+
+    var unknown []string
+    for k := range keys {
+        if !named[k] {
+            unknown = append(unknown, k)
+        }
+    }
+    sort.Strings(unknown)
+
+    if s.Extras != ExtrasColumn {
+        return headers, data, unknown
+    }
+    if !named[ExtrasHeader] {
+        headers = append(headers, ExtrasHeader)
+    }
+    rows := make([]map[string]string, len(data))
+    for i, row := range data {
+        var extras []string
+        for _, k := range unknown {
+            if v, ok := row[k]; ok {
+                extras = append(extras, k+"="+v)
+            }
+        }
+        copied := make(map[string]string, len(row)+1)
+        for k, v := range row {
+            copied[k] = v
+        }
+        copied[ExtrasHeader] = strings.Join(extras, ";")
+        rows[i] = copied
+    }
+    return headers, rows, unknown
+}
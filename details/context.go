@@ -0,0 +1,133 @@
+package details
+
+import (
+    "encoding/xml"
+    "io"
+    "os"
+    "strings"
+)
+
+// ContextResult is the regression outcome recorded in a cartridge's
+// context.xml, the Go counterpart of R's context_serial.
+type ContextResult struct {
+    Lot          string
+    SN           string
+    SerialNumber string
+    Result       string
+    ResultCodes  string
+    File         string
+}
+
+// xmlText collects all character data below an element. R's xmlValue runs
+// the text of child elements together; here the trimmed text of each
+// element is joined with ";" so that separate codes stay apart.
+type xmlText string
+
+func (t *xmlText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    var parts []string
+    var cur strings.Builder
+    depth := 1
+    for depth > 0 {
+        token, err := d.Token()
+        if err != nil {
+            return err
+        }
+        switch tok := token.(type) {
+        case xml.StartElement:
+            depth++
+        case xml.EndElement:
+            depth--
+            if s := strings.TrimSpace(cur.String()); s != "" {
+                parts = append(parts, s)
+            }
+            cur.Reset()
+        case xml.CharData:
+            cur.Write(tok)
+        }
+    }
+    *t = xmlText(strings.Join(parts, ";"))
+    return nil
+}
+
+// RegressionResult is the block of context.xml read by context_serial.
+type RegressionResult struct {
+    Result       xmlText `xml:"Result"`
+    SerialNumber xmlText `xml:"SerialNumber"`
+    ResultCodes  xmlText `xml:"ResultCodes"`
+}
+
+func GetContextXMLFiles(root string) ([]string, error) {
+    return findFiles(root, "context.xml")
+}
+
+func ExtractContextFromXML(fileName string) (ContextResult, error) {
+    ctx := ContextResult{File: fileName}
+
+    file, err := os.Open(fileName)
+    if err != nil {
+        return ctx, err
+    }
+    defer file.Close()
+
+    decoder := xml.NewDecoder(file)
+    var loadFilename string
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return ctx, err
+        }
+
+        se, ok := token.(xml.StartElement)
+        if !ok {
+            continue
+        }
+        switch se.Name.Local {
+        case "RegressionResult":
+            var rr RegressionResult
+            if err := decoder.DecodeElement(&rr, &se); err != nil {
+                return ctx, err
+            }
+            ctx.Result = string(rr.Result)
+            ctx.SerialNumber = string(rr.SerialNumber)
+            ctx.ResultCodes = string(rr.ResultCodes)
+        case "LoadFilename":
+            var name xmlText
+            if err := decoder.DecodeElement(&name, &se); err != nil {
+                return ctx, err
+            }
+            loadFilename = string(name)
+        }
+    }
+
+    // Serial numbers are zero-stripped to match the SN taken from the barcode
+    ctx.SN = strings.TrimLeft(ctx.SerialNumber, "0")
+    ctx.Lot = lotFromLoadFilename(loadFilename)
+
+    return ctx, nil
+}
+
+// lotFromLoadFilename returns the name of the directory two levels above
+// the loaded file, which is the lot folder. LoadFilename is written by the
+// Windows instrument software, so both separators are accepted.
+func lotFromLoadFilename(name string) string {
+    parts := strings.FieldsFunc(name, func(r rune) bool {
+        return r == '\\' || r == '/'
+    })
+    if len(parts) < 3 {
+        return ""
+    }
+    return parts[len(parts)-3]
+}
+
+// Row flattens the context result into CSV columns. Lot and SN use the
+// same names as the barcode columns so the two tables can be joined.
+func (c ContextResult) Row() map[string]string {
+    return map[string]string{
+        "Lot":         c.Lot,
+        "SN":          c.SN,
+        "Result":      c.Result,
+        "ResultCodes": c.ResultCodes,
+    }
+}
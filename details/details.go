@@ -0,0 +1,80 @@
+// Package details reads the inspection files written by the machine vision
+// software: details.xml with its embedded Results table, and context.xml
+// with the regression result of each cartridge.
+package details
+
+import (
+    "encoding/xml"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+type InspectionDetailsItem struct {
+    Name    string `xml:"Name"`
+    Details string `xml:"Details"`
+}
+
+type List struct {
+    Items []InspectionDetailsItem `xml:"InspectionDetailsItem"`
+}
+
+type Root struct {
+    List List `xml:"List"`
+}
+
+func GetDetailsXMLFiles(root string) ([]string, error) {
+    return findFiles(root, "details.xml")
+}
+
+func findFiles(root, name string) ([]string, error) {
+    var files []string
+
+    err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if !info.IsDir() && filepath.Base(path) == name {
+            files = append(files, path)
+        }
+        return nil
+    })
+
+    return files, err
+}
+
+func ExtractDetailsFromXML(fileName string) (string, string, error) {
+    file, err := os.Open(fileName)
+    if err != nil {
+        return "", "", err
+    }
+    defer file.Close()
+
+    decoder := xml.NewDecoder(file)
+    var barcode, results string
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return "", "", err
+        }
+
+        switch se := token.(type) {
+        case xml.StartElement:
+            if se.Name.Local == "InspectionDetailsItem" {
+                var item InspectionDetailsItem
+                if err := decoder.DecodeElement(&item, &se); err != nil {
+                    return "", "", err
+                }
+                if item.Name == "Bar Code" {
+                    barcode = item.Details
+                } else if item.Name == "Results" {
+                    results = item.Details
+                }
+            }
+        }
+    }
+
+    return barcode, results, nil
+}
@@ -0,0 +1,401 @@
+package details
+
+import (
+    "encoding/xml"
+    "fmt"
+    "io"
+    "regexp"
+    "strings"
+    "unicode"
+
+    "github.com/JARS3N/Vis/plate"
+)
+
+// Measurement is a single "name: value" pair read from a section of a well cell.
+type Measurement struct {
+    Name  string
+    Value string
+}
+
+// PortMeasurement is a per-port value such as "Port 2, diameter: 0.41".
+// Port is empty for values in the port section that name no port.
+type PortMeasurement struct {
+    Port  string
+    Name  string
+    Value string
+}
+
+// PortLayout records how the per-port section of a cell was labelled.
+type PortLayout int
+
+const (
+    // PortsLayout is the "Ports" heading; columns are named Port_<n>_<name>.
+    PortsLayout PortLayout = iota
+    // DrugLayout is the "Drug" heading read by the R package; columns are
+    // named Drug_Port_<n>_<name> to match pull_cells.
+    DrugLayout
+)
+
+// WellResult is one well cell of the Results table.
+type WellResult struct {
+    Well       string
+    Optical    []Measurement
+    Spot       []Measurement
+    Ports      []PortMeasurement
+    PortLayout PortLayout
+}
+
+// section identifies which block of a well cell a line belongs to.
+type section int
+
+const (
+    sectionNone section = iota
+    sectionOptical
+    sectionSpot
+    sectionPorts
+)
+
+var (
+    wellLabelRe   = regexp.MustCompile(`^[A-Za-z]{1,2}\d{1,2}$`)
+    keyValueRe    = regexp.MustCompile(`([^:]+):\s*([\d\.\-]+)`)
+    portKeyRe     = regexp.MustCompile(`(?i)^Port\s+([A-Za-z0-9]+)\s*,?\s*(.+)$`)
+    opticalHeadRe = regexp.MustCompile(`(?i)^optical\b`)
+    spotHeadRe    = regexp.MustCompile(`(?i)^spot\b\s*(\d*)`)
+    portsHeadRe   = regexp.MustCompile(`(?i)^ports?$`)
+    drugHeadRe    = regexp.MustCompile(`(?i)^drug\b`)
+
+    // Section headings that may run inline with the values around them,
+    // as in "Optical Window Area: 12 Spot 1 Area: 4 Ports Port 1, ...".
+    inlineHeadRe = regexp.MustCompile(`(?i)\b(?:optical window|spot\s+\d+|ports|drug)\b`)
+    // A heading word followed by a value is a name, as in "Ports: 4".
+    namedValueRe = regexp.MustCompile(`^\s*:\s*[\d\.\-]`)
+)
+
+// Elements whose text may be a well name or section heading.
+var labelElements = map[string]bool{
+    "b": true, "strong": true, "center": true, "th": true,
+    "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// Elements that end the current line of text.
+var breakElements = map[string]bool{
+    "br": true, "p": true, "div": true, "tr": true, "li": true, "table": true,
+    "center": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// cellText collects the text of one <td> as a list of lines. marks holds
+// the buffer offset at which each open label element started.
+type cellText struct {
+    lines []string
+    cur   strings.Builder
+    marks []int
+}
+
+func (c *cellText) emit(text string) {
+    text = strings.TrimSpace(text)
+    if text != "" {
+        c.lines = append(c.lines, text)
+    }
+}
+
+func (c *cellText) flush() {
+    c.emit(c.cur.String())
+    c.cur.Reset()
+    for i := range c.marks {
+        c.marks[i] = 0
+    }
+}
+
+// closeLabel ends a label element. Its text becomes a line of its own only
+// when it reads as a well name or section heading, so markup such as
+// "<b>Area:</b> 12" stays on one line. Text ending in ":" is a name
+// waiting for its value, so "<b>Spot Diameter:</b> 3.5" stays too.
+func (c *cellText) closeLabel() {
+    if len(c.marks) == 0 {
+        return
+    }
+    pos := c.marks[len(c.marks)-1]
+    c.marks = c.marks[:len(c.marks)-1]
+
+    text := c.cur.String()
+    if pos > len(text) {
+        pos = len(text)
+    }
+    label := strings.TrimSpace(text[pos:])
+    if strings.HasSuffix(label, ":") || !isLabel(label) {
+        return
+    }
+    c.emit(text[:pos])
+    c.emit(label)
+    c.cur.Reset()
+    for i := range c.marks {
+        c.marks[i] = 0
+    }
+}
+
+// ParseResults walks the Results HTML with a tokenizer and returns one
+// WellResult per well cell, in document order.
+func ParseResults(results string) ([]WellResult, error) {
+    decoder := xml.NewDecoder(strings.NewReader(results))
+    decoder.Strict = false
+    decoder.AutoClose = xml.HTMLAutoClose
+    decoder.Entity = xml.HTMLEntity
+
+    var wells []WellResult
+    var cells []*cellText // open <td> elements, innermost last
+
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return wells, fmt.Errorf("results table: %w", err)
+        }
+
+        switch t := token.(type) {
+        case xml.StartElement:
+            name := strings.ToLower(t.Name.Local)
+            if name == "td" {
+                cells = append(cells, &cellText{})
+                continue
+            }
+            if len(cells) == 0 {
+                continue
+            }
+            cell := cells[len(cells)-1]
+            if breakElements[name] {
+                cell.flush()
+            }
+            if labelElements[name] {
+                cell.marks = append(cell.marks, cell.cur.Len())
+            }
+        case xml.EndElement:
+            name := strings.ToLower(t.Name.Local)
+            if len(cells) == 0 {
+                continue
+            }
+            cell := cells[len(cells)-1]
+            if name == "td" {
+                cell.flush()
+                cells = cells[:len(cells)-1]
+                if well, ok := parseCell(cell.lines); ok {
+                    wells = append(wells, well)
+                }
+                continue
+            }
+            if labelElements[name] {
+                cell.closeLabel()
+            }
+            if breakElements[name] {
+                cell.flush()
+            }
+        case xml.CharData:
+            if len(cells) == 0 {
+                continue
+            }
+            // &nbsp; decodes to U+00A0, which the patterns do not read as
+            // a space
+            cells[len(cells)-1].cur.WriteString(strings.Map(plainSpace, string(t)))
+        }
+    }
+
+    return wells, nil
+}
+
+// cellParser holds the section a cell has reached while its lines are read.
+type cellParser struct {
+    well    WellResult
+    current section
+    spot    string // number of the spot block after the first, "" for the first
+}
+
+// parseCell assigns the lines of a cell to the well label and its sections.
+// Cells that carry neither a well label nor any measurement are skipped.
+func parseCell(lines []string) (WellResult, bool) {
+    var p cellParser
+
+    for _, line := range lines {
+        if heading, ok := headingText(line); ok {
+            if p.well.Well == "" && p.current == sectionNone && wellLabelRe.MatchString(heading) {
+                p.well.Well = plate.ZeroPadWell(heading)
+                continue
+            }
+            p.enter(heading)
+            continue
+        }
+
+        // Headings need not sit on a line of their own; split them out
+        // and read the values between them
+        rest := line
+        for {
+            loc := nextHeading(rest)
+            if loc == nil {
+                p.values(rest)
+                break
+            }
+            p.values(rest[:loc[0]])
+            p.enter(rest[loc[0]:loc[1]])
+            rest = rest[loc[1]:]
+        }
+    }
+
+    well := p.well
+
+    if well.Well == "" && len(well.Optical) == 0 && len(well.Spot) == 0 && len(well.Ports) == 0 {
+        return well, false
+    }
+    return well, true
+}
+
+// nextHeading locates the first inline section heading in text, skipping
+// heading words that are the name of a value.
+func nextHeading(text string) []int {
+    offset := 0
+    for {
+        loc := inlineHeadRe.FindStringIndex(text[offset:])
+        if loc == nil {
+            return nil
+        }
+        start, end := offset+loc[0], offset+loc[1]
+        if !namedValueRe.MatchString(text[end:]) {
+            return []int{start, end}
+        }
+        offset = end
+    }
+}
+
+// enter moves to the section a heading names; other headings are ignored.
+func (p *cellParser) enter(heading string) {
+    s := sectionFor(heading)
+    if s == sectionNone {
+        return
+    }
+    p.current = s
+    switch s {
+    case sectionSpot:
+        // Spot 1 keeps the Spot_<name> columns; later spots are numbered
+        p.spot = spotHeadRe.FindStringSubmatch(heading)[1]
+        if p.spot == "1" {
+            p.spot = ""
+        }
+    case sectionPorts:
+        if drugHeadRe.MatchString(heading) {
+            p.well.PortLayout = DrugLayout
+        }
+    }
+}
+
+// values reads the "name: value" pairs of text into the current section.
+func (p *cellParser) values(text string) {
+    port := "" // last port named in the port section
+
+    for _, match := range keyValueRe.FindAllStringSubmatch(text, -1) {
+        name := strings.TrimSpace(match[1])
+        value := strings.TrimSpace(match[2])
+        switch p.current {
+        case sectionOptical:
+            p.well.Optical = append(p.well.Optical, Measurement{Name: columnName(name), Value: value})
+        case sectionSpot:
+            name = columnName(name)
+            if p.spot != "" {
+                name = p.spot + "_" + name
+            }
+            p.well.Spot = append(p.well.Spot, Measurement{Name: name, Value: value})
+        case sectionPorts:
+            // "Port A, diameter: 0.41, x: 1.2" names the port once;
+            // later values on the line belong to the same port.
+            if m := portKeyRe.FindStringSubmatch(name); m != nil {
+                port = m[1]
+                name = m[2]
+            } else if strings.HasPrefix(name, ",") {
+                name = strings.TrimLeft(name, ", ")
+            } else {
+                port = ""
+            }
+            p.well.Ports = append(p.well.Ports, PortMeasurement{
+                Port:  port,
+                Name:  columnName(name),
+                Value: value,
+            })
+        }
+    }
+}
+
+// headingText reports whether a line carries no value, returning it without
+// a trailing colon ("Optical Window:" reads as "Optical Window").
+func headingText(line string) (string, bool) {
+    text := strings.TrimSpace(strings.TrimSuffix(line, ":"))
+    if text == "" || strings.Contains(text, ":") {
+        return "", false
+    }
+    return text, true
+}
+
+// plainSpace turns non-breaking and other Unicode spaces into plain ones.
+func plainSpace(r rune) rune {
+    if unicode.Is(unicode.Zs, r) {
+        return ' '
+    }
+    return r
+}
+
+func isLabel(text string) bool {
+    heading, ok := headingText(text)
+    if !ok {
+        return false
+    }
+    return wellLabelRe.MatchString(heading) || sectionFor(heading) != sectionNone
+}
+
+func sectionFor(heading string) section {
+    switch {
+    case opticalHeadRe.MatchString(heading):
+        return sectionOptical
+    case spotHeadRe.MatchString(heading):
+        return sectionSpot
+    case portsHeadRe.MatchString(heading), drugHeadRe.MatchString(heading):
+        return sectionPorts
+    }
+    return sectionNone
+}
+
+func columnName(name string) string {
+    name = strings.TrimSpace(name)
+    name = strings.ReplaceAll(name, " ", "_")
+    name = strings.ReplaceAll(name, "-", "_")
+    return name
+}
+
+// Row flattens the well into the column names used in the CSV output.
+func (w WellResult) Row() map[string]string {
+    row := make(map[string]string)
+    for _, m := range w.Optical {
+        row["Optical_"+m.Name] = m.Value
+    }
+    for _, m := range w.Spot {
+        row["Spot_"+m.Name] = m.Value
+    }
+    for _, p := range w.Ports {
+        row[w.PortColumn(p)] = p.Value
+    }
+    if w.Well != "" {
+        row["Well"] = w.Well
+    }
+    return row
+}
+
+// PortColumn names the column of a port measurement, following the port
+// layout of the well.
+func (w WellResult) PortColumn(p PortMeasurement) string {
+    prefix := "Port_"
+    if w.PortLayout == DrugLayout {
+        prefix = "Drug_"
+        if p.Port != "" {
+            prefix += "Port_"
+        }
+    }
+    if p.Port == "" {
+        return prefix + p.Name
+    }
+    return fmt.Sprintf("%s%s_%s", prefix, p.Port, p.Name)
+}
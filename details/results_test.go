@@ -0,0 +1,161 @@
+package details
+
+import (
+    "fmt"
+    "reflect"
+    "regexp"
+    "strings"
+    "testing"
+
+    "github.com/JARS3N/Vis/plate"
+)
+
+// baselineProcessRow is ProcessRow from the regex scraper that ParseResults
+// replaced, kept to check that current files give the same columns.
+func baselineProcessRow(rowText string) map[string]string {
+    dataframe := make(map[string]string)
+
+    wellRe := regexp.MustCompile(`<center><b>([A-Za-z]+\d+)</b></center>`)
+    wellMatch := wellRe.FindStringSubmatch(rowText)
+    if len(wellMatch) > 1 {
+        dataframe["Well"] = plate.ZeroPadWell(wellMatch[1])
+    }
+
+    opticalSectionRe := regexp.MustCompile(`Optical Window(.*?)Spot 1`)
+    if m := opticalSectionRe.FindStringSubmatch(rowText); len(m) > 1 {
+        baselineKeyValuePairs(m[1], "Optical_", dataframe)
+    }
+
+    spotSectionRe := regexp.MustCompile(`Spot 1(.*?)Ports`)
+    if m := spotSectionRe.FindStringSubmatch(rowText); len(m) > 1 {
+        baselineKeyValuePairs(m[1], "Spot_", dataframe)
+    }
+
+    portsSectionRe := regexp.MustCompile(`Ports(.*)`)
+    if m := portsSectionRe.FindStringSubmatch(rowText); len(m) > 1 {
+        baselinePortKeyValuePairs(m[1], dataframe)
+    }
+
+    return dataframe
+}
+
+func baselineKeyValuePairs(text, prefix string, dataframe map[string]string) {
+    cleanText := regexp.MustCompile(`<[^>]*>`).ReplaceAllString(text, "")
+    re := regexp.MustCompile(`([^:]+):\s*([\d\.\-]+)`)
+    for _, match := range re.FindAllStringSubmatch(cleanText, -1) {
+        key := strings.TrimSpace(match[1])
+        key = strings.ReplaceAll(key, " ", "_")
+        key = strings.ReplaceAll(key, "-", "_")
+        dataframe[prefix+key] = strings.TrimSpace(match[2])
+    }
+}
+
+func baselinePortKeyValuePairs(text string, dataframe map[string]string) {
+    cleanText := regexp.MustCompile(`<[^>]*>`).ReplaceAllString(text, "")
+    re := regexp.MustCompile(`Port (\d+), diameter:\s*([\d\.\-]+)`)
+    for _, match := range re.FindAllStringSubmatch(cleanText, -1) {
+        dataframe[fmt.Sprintf("Port_%s_diameter", match[1])] = strings.TrimSpace(match[2])
+    }
+}
+
+// parseCellRow runs ParseResults on a table holding the one cell.
+func parseCellRow(t *testing.T, cell string) map[string]string {
+    t.Helper()
+    wells, err := ParseResults("<table><tr><td>" + cell + "</td></tr></table>")
+    if err != nil {
+        t.Fatalf("ParseResults: %v", err)
+    }
+    if len(wells) != 1 {
+        t.Fatalf("ParseResults returned %d wells, want 1", len(wells))
+    }
+    return wells[0].Row()
+}
+
+func TestParseResultsMatchesBaseline(t *testing.T) {
+    cells := map[string]string{
+        "current": `<center><b>A1</b></center><b>Optical Window</b><br>Area: 12<br>Perimeter: 30.5<br>` +
+            `<b>Spot 1</b><br>Area: 4<br>Offset X: -0.2<br>` +
+            `<b>Ports</b><br>Port 1, diameter: 0.41<br>Port 2, diameter: 0.39<br>`,
+        "inline": `<center><b>A1</b></center>Optical Window Area: 12 Spot 1 Area: 4 Ports Port 1, diameter: 0.4`,
+        "bold label": `<center><b>A1</b></center><b>Optical Window</b><br><b>Area:</b> 12<br>` +
+            `<b>Spot 1</b><br><b>Spot Diameter:</b> 3.5<br>` +
+            `<b>Ports</b><br><b>Port 1, diameter:</b> 0.4`,
+        "nested tags": `<center><b>A1</b></center><font color="blue"><b>Optical Window</b></font><br>` +
+            `<span>Area: <i>12</i></span><br><span class="spot"><b>Spot 1</b></span><br>` +
+            `<span>Area: <i>4</i></span><br><b>Ports</b><br><span>Port 1, diameter: <i>0.4</i></span>`,
+    }
+
+    for name, cell := range cells {
+        t.Run(name, func(t *testing.T) {
+            want := baselineProcessRow(cell)
+            if len(want) < 4 {
+                t.Fatalf("baseline read only %v; the case is not a current file", want)
+            }
+            if got := parseCellRow(t, cell); !reflect.DeepEqual(got, want) {
+                t.Errorf("ParseResults row = %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+func TestParseResultsLayouts(t *testing.T) {
+    cases := []struct {
+        name string
+        cell string
+        want map[string]string
+    }{
+        {
+            // The baseline regexes stopped at the first line break
+            name: "line breaks",
+            cell: "<center><b>A1</b></center>\n<b>Optical Window</b>\n<br>Area: 12\n" +
+                "<br><b>Spot 1</b>\n<br>Area: 4\n<br><b>Ports</b>\n<br>Port 1, diameter: 0.4\n",
+            want: map[string]string{
+                "Well": "A01", "Optical_Area": "12", "Spot_Area": "4", "Port_1_diameter": "0.4",
+            },
+        },
+        {
+            name: "numbered spots",
+            cell: `<center><b>A1</b></center><b>Optical Window</b><br>Area: 12<br>` +
+                `<b>Spot 1</b><br>Area: 4<br><b>Spot 2</b><br>Area: 9<br>` +
+                `<b>Ports</b><br>Port 1, diameter: 0.4`,
+            want: map[string]string{
+                "Well": "A01", "Optical_Area": "12", "Spot_Area": "4", "Spot_2_Area": "9",
+                "Port_1_diameter": "0.4",
+            },
+        },
+        {
+            name: "drug",
+            cell: `<center><b>A1</b></center><b>Optical Window</b><br>Area (um): 1.5<br>` +
+                `<b>Spot 1</b><br>Area: 3<br><b>Drug</b><br>Port A, diameter: 0.41<br>Port B, diameter: -<br>`,
+            want: map[string]string{
+                "Well": "A01", "Optical_Area_(um)": "1.5", "Spot_Area": "3",
+                "Drug_Port_A_diameter": "0.41", "Drug_Port_B_diameter": "-",
+            },
+        },
+        {
+            name: "port attributes",
+            cell: `<center><b>A1</b></center><b>Optical Window</b><br>Area: 12<br>` +
+                `<b>Spot 1</b><br>Area: 4<br><b>Ports</b><br>Port A, diameter: 0.41, x: 1.2`,
+            want: map[string]string{
+                "Well": "A01", "Optical_Area": "12", "Spot_Area": "4",
+                "Port_A_diameter": "0.41", "Port_A_x": "1.2",
+            },
+        },
+        {
+            name: "non-breaking spaces",
+            cell: `<center><b>A1</b></center><b>Optical&nbsp;Window</b><br>Area:&nbsp;12<br>` +
+                `<b>Spot 1</b><br>Offset&nbsp;X:&nbsp;-0.2<br><b>Ports</b><br>Port&nbsp;1,&nbsp;diameter:&nbsp;0.4`,
+            want: map[string]string{
+                "Well": "A01", "Optical_Area": "12", "Spot_Offset_X": "-0.2", "Port_1_diameter": "0.4",
+            },
+        },
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            if got := parseCellRow(t, c.cell); !reflect.DeepEqual(got, c.want) {
+                t.Errorf("ParseResults row = %v, want %v", got, c.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,152 @@
+// Package jsonout writes inspection results as JSON, keeping the hierarchy
+// the CSV flattens: lot, cartridges, wells and their sections. The field
+// names are described by the JSON Schema in schema.json, whose version is
+// SchemaVersion.
+package jsonout
+
+import (
+    _ "embed"
+    "encoding/json"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/JARS3N/Vis/atomicfile"
+    "github.com/JARS3N/Vis/details"
+)
+
+// SchemaVersion is written to every document and line. Bump it, and
+// schema.json, whenever a field is renamed or removed.
+const SchemaVersion = 1
+
+// Schema is the JSON Schema of the documents and lines.
+//
+//go:embed schema.json
+var Schema []byte
+
+// Lot is the nested JSON document of one lot.
+type Lot struct {
+    SchemaVersion int         `json:"schema_version"`
+    Lot           string      `json:"lot"`
+    Instrument    string      `json:"instrument"`
+    Cartridges    []Cartridge `json:"cartridges"`
+}
+
+// Cartridge is one details.xml file. In JSON Lines output each cartridge is
+// a line and carries the schema version itself.
+type Cartridge struct {
+    SchemaVersion  int    `json:"schema_version,omitempty"`
+    Lot            string `json:"lot"`
+    SN             string `json:"sn"`
+    Type           string `json:"type"`
+    Instrument     string `json:"instrument"`
+    Barcode        string `json:"barcode"`
+    BarcodeError   string `json:"barcode_error,omitempty"`
+    SourceFile     string `json:"source_file"`
+    WellValidation string `json:"well_validation"`
+    Result         string `json:"result,omitempty"`       // from context.xml when joined
+    ResultCodes    string `json:"result_codes,omitempty"` // from context.xml when joined
+    Wells          []Well `json:"wells"`
+}
+
+// Well is one well cell of the Results table.
+type Well struct {
+    Well       string   `json:"well"`
+    PortLayout string   `json:"port_layout"` // "ports" or "drug"
+    Sections   Sections `json:"sections"`
+}
+
+// Sections holds the measurements of a well by section.
+type Sections struct {
+    Optical []Measurement     `json:"optical"`
+    Spot    []Measurement     `json:"spot"`
+    Ports   []PortMeasurement `json:"ports"`
+}
+
+// Measurement is a named value. Value is null when Text is not a number.
+type Measurement struct {
+    Name  string   `json:"name"`
+    Value *float64 `json:"value"`
+    Text  string   `json:"text"`
+}
+
+// PortMeasurement is a measurement of one port; Port is omitted for values
+// that name no port.
+type PortMeasurement struct {
+    Port string `json:"port,omitempty"`
+    Measurement
+}
+
+// NewWell converts a parsed well cell.
+func NewWell(w details.WellResult) Well {
+    well := Well{
+        Well:       w.Well,
+        PortLayout: "ports",
+        Sections: Sections{
+            Optical: make([]Measurement, 0, len(w.Optical)),
+            Spot:    make([]Measurement, 0, len(w.Spot)),
+            Ports:   make([]PortMeasurement, 0, len(w.Ports)),
+        },
+    }
+    if w.PortLayout == details.DrugLayout {
+        well.PortLayout = "drug"
+    }
+    for _, m := range w.Optical {
+        well.Sections.Optical = append(well.Sections.Optical, newMeasurement(m.Name, m.Value))
+    }
+    for _, m := range w.Spot {
+        well.Sections.Spot = append(well.Sections.Spot, newMeasurement(m.Name, m.Value))
+    }
+    for _, p := range w.Ports {
+        well.Sections.Ports = append(well.Sections.Ports, PortMeasurement{Port: p.Port, Measurement: newMeasurement(p.Name, p.Value)})
+    }
+    return well
+}
+
+func newMeasurement(name, text string) Measurement {
+    m := Measurement{Name: name, Text: text}
+    if f, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+        m.Value = &f
+    }
+    return m
+}
+
+// WriteLines writes one cartridge per line to filePath.
+func WriteLines(filePath string, cartridges []Cartridge) error {
+    return atomicfile.Write(filePath, func(w io.Writer) error {
+        enc := json.NewEncoder(w)
+        enc.SetEscapeHTML(false)
+        for _, c := range cartridges {
+            c.SchemaVersion = SchemaVersion
+            if err := enc.Encode(c); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// WriteDocument writes the lot as one indented JSON document to filePath.
+func WriteDocument(filePath string, lot Lot) error {
+    lot.SchemaVersion = SchemaVersion
+    return atomicfile.Write(filePath, func(w io.Writer) error {
+        enc := json.NewEncoder(w)
+        enc.SetEscapeHTML(false)
+        enc.SetIndent("", "  ")
+        return enc.Encode(lot)
+    })
+}
+
+// WriteDocuments writes the lots as one indented JSON array of lot
+// documents to filePath.
+func WriteDocuments(filePath string, lots []Lot) error {
+    for i := range lots {
+        lots[i].SchemaVersion = SchemaVersion
+    }
+    return atomicfile.Write(filePath, func(w io.Writer) error {
+        enc := json.NewEncoder(w)
+        enc.SetEscapeHTML(false)
+        enc.SetIndent("", "  ")
+        return enc.Encode(lots)
+    })
+}
@@ -0,0 +1,104 @@
+// Package parquetout writes tables as Parquet files with typed columns, for
+// readers such as R arrow, pandas and DuckDB that would otherwise have to
+// guess the types of every CSV column.
+package parquetout
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/xitongsys/parquet-go-source/writerfile"
+    "github.com/xitongsys/parquet-go/writer"
+
+    "github.com/JARS3N/Vis/atomicfile"
+)
+
+// measurementPrefixes mark the columns written as float64. The type comes
+// from the name alone, so every file of a dataset has the same schema.
+var measurementPrefixes = []string{"Optical_", "Spot_", "Port_", "Drug_"}
+
+// column is a Parquet column and the row key it is read from.
+type column struct {
+    key     string
+    numeric bool
+}
+
+// Write writes the rows to filePath under the given header, as laid out
+// by a csvout.Schema. Measurement columns are float64, holding null for
+// values that are not numbers, such as "-"; Lot, SN, Type, Well and every
+// other column are strings. Empty cells are null. Like the CSV, the file
+// is written atomically.
+func Write(filePath string, headers []string, data []map[string]string) error {
+    if len(data) == 0 {
+        return nil
+    }
+    columns := columnsFor(headers)
+    return atomicfile.Write(filePath, func(w io.Writer) error {
+        return writeRows(w, columns, data)
+    })
+}
+
+func writeRows(w io.Writer, columns []column, data []map[string]string) error {
+    // The writer's schema tags are comma separated key=value pairs, and
+    // it keeps only letters, digits and _ in the names it works with. The
+    // columns are declared as C0, C1, ... and given their header as the
+    // name written to the file, so any header is kept as it is.
+    md := make([]string, len(columns))
+    for i, c := range columns {
+        if c.numeric {
+            md[i] = fmt.Sprintf("name=C%d, type=DOUBLE, repetitiontype=OPTIONAL", i)
+        } else {
+            md[i] = fmt.Sprintf("name=C%d, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", i)
+        }
+    }
+
+    pw, err := writer.NewCSVWriter(md, writerfile.NewWriterFile(w), 1)
+    if err != nil {
+        return err
+    }
+    for i, c := range columns {
+        pw.SchemaHandler.Infos[i+1].ExName = c.key // Infos[0] is the root
+    }
+    pw.SchemaHandler.CreateInExMap()
+
+    for _, row := range data {
+        record := make([]interface{}, len(columns))
+        for i, c := range columns {
+            text := strings.TrimSpace(row[c.key])
+            if text == "" {
+                continue
+            }
+            if c.numeric {
+                if f, err := strconv.ParseFloat(text, 64); err == nil {
+                    record[i] = f
+                }
+            } else {
+                record[i] = row[c.key]
+            }
+        }
+        if err := pw.Write(record); err != nil {
+            return err
+        }
+    }
+    return pw.WriteStop()
+}
+
+// columnsFor types each header: numeric when it is a measurement column.
+func columnsFor(headers []string) []column {
+    columns := make([]column, len(headers))
+    for i, key := range headers {
+        columns[i] = column{key: key, numeric: isMeasurement(key)}
+    }
+    return columns
+}
+
+func isMeasurement(key string) bool {
+    for _, prefix := range measurementPrefixes {
+        if strings.HasPrefix(key, prefix) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,47 @@
+package parquetout
+
+import (
+    "path/filepath"
+    "testing"
+
+    "github.com/xitongsys/parquet-go-source/local"
+    "github.com/xitongsys/parquet-go/reader"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "B12345_MV.parquet")
+    headers := []string{"Lot", "Optical_Area_(um)", "Spot_Diameter (um)", "Drug_Port_A_x,y"}
+    data := []map[string]string{
+        {"Lot": "B12345", "Optical_Area_(um)": "1.5", "Spot_Diameter (um)": "3.4", "Drug_Port_A_x,y": "-"},
+    }
+    if err := Write(path, headers, data); err != nil {
+        t.Fatalf("Write: %v", err)
+    }
+
+    file, err := local.NewLocalFileReader(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    pr, err := reader.NewParquetColumnReader(file, 1)
+    if err != nil {
+        t.Fatalf("reading %s: %v", path, err)
+    }
+    defer pr.ReadStop()
+
+    want := []interface{}{"B12345", 1.5, 3.4, nil}
+    for i, header := range headers {
+        // The reader names columns after identifiers; the name in the
+        // file is the external one
+        if name := pr.SchemaHandler.GetExName(i + 1); name != header {
+            t.Errorf("column %d is named %q, want %q", i, name, header)
+        }
+        values, _, _, err := pr.ReadColumnByIndex(int64(i), 1)
+        if err != nil {
+            t.Fatalf("reading column %s: %v", header, err)
+        }
+        if len(values) != 1 || values[0] != want[i] {
+            t.Errorf("column %s holds %v, want [%v]", header, values, want[i])
+        }
+    }
+}
@@ -0,0 +1,145 @@
+// Package plate describes the well layout of each cartridge type and checks
+// parsed wells against it.
+package plate
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Geometry describes the wells of one cartridge type. Rows are named
+// by consecutive letters starting at A, columns are numbered from 1.
+type Geometry struct {
+    Instrument string
+    Rows       int
+    Columns    int
+}
+
+// geometries is keyed by the instrument from the barcode type letter.
+var geometries = map[string]Geometry{
+    "XFe24": {Instrument: "XFe24", Rows: 4, Columns: 6},
+    "XFe96": {Instrument: "XFe96", Rows: 8, Columns: 12},
+    "XFp":   {Instrument: "XFp", Rows: 8, Columns: 1},
+}
+
+var wellNameRe = regexp.MustCompile(`^([A-Za-z])(\d+)$`)
+
+// GeometryFor returns the geometry registered for an instrument.
+func GeometryFor(instrument string) (Geometry, bool) {
+    g, ok := geometries[instrument]
+    return g, ok
+}
+
+// Wells lists every well of the plate, zero-padded as in the CSV output.
+func (g Geometry) Wells() []string {
+    wells := make([]string, 0, g.Rows*g.Columns)
+    for r := 0; r < g.Rows; r++ {
+        for c := 1; c <= g.Columns; c++ {
+            wells = append(wells, fmt.Sprintf("%c%02d", 'A'+r, c))
+        }
+    }
+    return wells
+}
+
+// Contains reports whether the well lies on the plate.
+func (g Geometry) Contains(well string) bool {
+    match := wellNameRe.FindStringSubmatch(well)
+    if match == nil {
+        return false
+    }
+    row := int(strings.ToUpper(match[1])[0] - 'A')
+    col, err := strconv.Atoi(match[2])
+    if err != nil {
+        return false
+    }
+    return row < g.Rows && col >= 1 && col <= g.Columns
+}
+
+// WellCheck is the outcome of validating the wells of one cartridge.
+type WellCheck struct {
+    Instrument string
+    NoGeometry bool
+    Missing    []string
+    Duplicate  []string
+    OutOfRange []string
+}
+
+// ValidateWells checks the wells parsed from one cartridge against the
+// plate geometry of its instrument.
+func ValidateWells(instrument string, wells []string) WellCheck {
+    check := WellCheck{Instrument: instrument}
+    g, ok := GeometryFor(instrument)
+    if !ok {
+        check.NoGeometry = true
+        return check
+    }
+
+    counts := make(map[string]int)
+    for _, well := range wells {
+        well = ZeroPadWell(strings.ToUpper(well))
+        counts[well]++
+        if counts[well] == 2 {
+            check.Duplicate = append(check.Duplicate, well)
+        }
+        if counts[well] == 1 && !g.Contains(well) {
+            check.OutOfRange = append(check.OutOfRange, well)
+        }
+    }
+    for _, well := range g.Wells() {
+        if counts[well] == 0 {
+            check.Missing = append(check.Missing, well)
+        }
+    }
+
+    sort.Strings(check.Duplicate)
+    sort.Strings(check.OutOfRange)
+    return check
+}
+
+// OK reports whether the cartridge has exactly the wells of its plate.
+func (c WellCheck) OK() bool {
+    return !c.NoGeometry && len(c.Missing) == 0 && len(c.Duplicate) == 0 && len(c.OutOfRange) == 0
+}
+
+// String is the value written to the WellValidation column.
+func (c WellCheck) String() string {
+    if c.NoGeometry {
+        if c.Instrument == "" {
+            return "no plate geometry: unknown instrument"
+        }
+        return fmt.Sprintf("no plate geometry for %s", c.Instrument)
+    }
+    if c.OK() {
+        return "ok"
+    }
+
+    var problems []string
+    if len(c.Missing) > 0 {
+        problems = append(problems, "missing "+strings.Join(c.Missing, " "))
+    }
+    if len(c.Duplicate) > 0 {
+        problems = append(problems, "duplicate "+strings.Join(c.Duplicate, " "))
+    }
+    if len(c.OutOfRange) > 0 {
+        problems = append(problems, "out of range "+strings.Join(c.OutOfRange, " "))
+    }
+    return strings.Join(problems, "; ")
+}
+
+// ZeroPadWell pads single digit well numbers, so "A1" becomes "A01".
+func ZeroPadWell(well string) string {
+    wellRe := regexp.MustCompile(`([A-Za-z]+)(\d+)`)
+    wellMatch := wellRe.FindStringSubmatch(well)
+    if len(wellMatch) > 2 {
+        letter := wellMatch[1]
+        number := wellMatch[2]
+        if len(number) == 1 {
+            number = "0" + number
+        }
+        return letter + number
+    }
+    return well
+}
@@ -0,0 +1,164 @@
+// Package site loads the paths of a site's machine vision share, shared by
+// viswrangler and vis_worker.
+package site
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "time"
+
+    "github.com/BurntSushi/toml"
+
+    "github.com/JARS3N/Vis/barcode"
+    "github.com/JARS3N/Vis/csvout"
+)
+
+const (
+    settingsFile      = "vis.toml"
+    defaultMaxRetries = 3
+
+    // A lot folder must be quiet this long before watch mode processes it;
+    // the instruments write a run's files over several seconds.
+    defaultQuietPeriod  = 2 * time.Minute
+    defaultPollInterval = time.Minute
+
+    // instrumentTypesEnv names extra type letters as "D=XFe96,E=XFp".
+    instrumentTypesEnv = "VIS_INSTRUMENT_TYPES"
+)
+
+// Settings holds the site paths.
+// Values come from the built-in defaults, then vis.toml, then the VIS_*
+// environment variables.
+type Settings struct {
+    SearchRoot      string            `toml:"search_root"`
+    CSVDir          string            `toml:"csv_dir"`
+    DBPath          string            `toml:"db_path"`
+    PlatformDirs    []string          `toml:"platform_dirs"`
+    InstrumentTypes map[string]string `toml:"instrument_types"`
+    MaxRetries      int               `toml:"max_retries"`
+    QuietPeriod     time.Duration     `toml:"quiet_period"`  // watch mode debounce
+    PollInterval    time.Duration     `toml:"poll_interval"` // watch mode rescan
+    Columns         []string          `toml:"columns"`       // wide CSV and Parquet columns
+    Extras          string            `toml:"extras"`        // policy for keys not in Columns
+}
+
+// Schema returns the column schema of the wide CSV and Parquet files:
+// the configured columns, or the built-in ones when none are set.
+func (s Settings) Schema() csvout.Schema {
+    return csvout.Schema{Columns: s.Columns, Extras: s.Extras}
+}
+
+// defaultSearchRoot mirrors R's update(), which reads the same share from
+// G: on Windows and from /mnt/LSAG on Linux.
+func defaultSearchRoot() string {
+    if runtime.GOOS == "windows" {
+        return `G:\Spotting\Logging`
+    }
+    return filepath.Join("/mnt", "LSAG", "Spotting", "Logging")
+}
+
+// settingsPath returns the config file to read: VIS_CONFIG if set, else
+// vis.toml next to the executable, else vis.toml in the working directory.
+// It returns "" when there is none.
+func settingsPath() string {
+    if path := os.Getenv("VIS_CONFIG"); path != "" {
+        return path
+    }
+    if exePath, err := os.Executable(); err == nil {
+        path := filepath.Join(filepath.Dir(exePath), settingsFile)
+        if _, err := os.Stat(path); err == nil {
+            return path
+        }
+    }
+    if _, err := os.Stat(settingsFile); err == nil {
+        return settingsFile
+    }
+    return ""
+}
+
+// Load reads the config file and environment overrides and fills
+// in the paths that were left unset.
+func Load() (Settings, error) {
+    var s Settings
+
+    if path := settingsPath(); path != "" {
+        if _, err := toml.DecodeFile(path, &s); err != nil {
+            return s, fmt.Errorf("reading %s: %w", path, err)
+        }
+    }
+
+    if v := os.Getenv("VIS_SEARCH_ROOT"); v != "" {
+        s.SearchRoot = v
+    }
+    if v := os.Getenv("VIS_CSV_DIR"); v != "" {
+        s.CSVDir = v
+    }
+    if v := os.Getenv("VIS_DB_PATH"); v != "" {
+        s.DBPath = v
+    }
+    if v := os.Getenv("VIS_PLATFORM_DIRS"); v != "" {
+        s.PlatformDirs = filepath.SplitList(v)
+    }
+    if v := os.Getenv("VIS_MAX_RETRIES"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil {
+            return s, fmt.Errorf("reading VIS_MAX_RETRIES: %w", err)
+        }
+        s.MaxRetries = n
+    }
+    if v := os.Getenv("VIS_QUIET_PERIOD"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil {
+            return s, fmt.Errorf("reading VIS_QUIET_PERIOD: %w", err)
+        }
+        s.QuietPeriod = d
+    }
+    if v := os.Getenv("VIS_POLL_INTERVAL"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil {
+            return s, fmt.Errorf("reading VIS_POLL_INTERVAL: %w", err)
+        }
+        s.PollInterval = d
+    }
+
+    if s.SearchRoot == "" {
+        s.SearchRoot = defaultSearchRoot()
+    }
+    if s.CSVDir == "" {
+        s.CSVDir = filepath.Join(s.SearchRoot, "CSVs")
+    }
+    if s.DBPath == "" {
+        s.DBPath = filepath.Join(s.CSVDir, "machine-vision.sqlite")
+    }
+    if s.MaxRetries <= 0 {
+        s.MaxRetries = defaultMaxRetries
+    }
+    if s.QuietPeriod <= 0 {
+        s.QuietPeriod = defaultQuietPeriod
+    }
+    if s.PollInterval <= 0 {
+        s.PollInterval = defaultPollInterval
+    }
+    if err := s.Schema().Validate(); err != nil {
+        return s, err
+    }
+    if len(s.PlatformDirs) == 0 {
+        for _, platform := range []string{"XFe24", "XFe96", "XFp"} {
+            s.PlatformDirs = append(s.PlatformDirs, filepath.Join(s.SearchRoot, platform))
+        }
+    }
+
+    for letter, instrument := range s.InstrumentTypes {
+        if err := barcode.AddInstrumentTypes(letter + "=" + instrument); err != nil {
+            return s, err
+        }
+    }
+    if err := barcode.AddInstrumentTypes(os.Getenv(instrumentTypesEnv)); err != nil {
+        return s, fmt.Errorf("reading %s: %w", instrumentTypesEnv, err)
+    }
+
+    return s, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "time"
+)
+
+// LeaseTTL is how long a lease survives without a heartbeat. A worker that
+// crashed or lost the share is taken over once it has been silent this
+// long.
+const LeaseTTL = 2 * time.Minute
+
+// ErrLeaseHeld is returned when another worker holds a live lease.
+var ErrLeaseHeld = errors.New("another worker holds the lease")
+
+// Lease is the single-instance lock of a worker, kept as one row of
+// worker_lease so it works for workers on different machines sharing the
+// database.
+type Lease struct {
+    db    *sql.DB
+    Owner string
+    stop  chan struct{}
+    done  chan struct{}
+    lost  chan struct{}
+}
+
+// AcquireLease takes the lease if it is free, stale or already ours, and
+// keeps it alive with a heartbeat until Release. It returns an error
+// wrapping ErrLeaseHeld when another worker holds it.
+func AcquireLease(db *sql.DB) (*Lease, error) {
+    host, _ := os.Hostname()
+    owner := fmt.Sprintf("%s pid %d", host, os.Getpid())
+
+    now := time.Now()
+    res, err := db.Exec(`INSERT INTO worker_lease (id, owner, acquired, heartbeat) VALUES (1, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, acquired = excluded.acquired, heartbeat = excluded.heartbeat
+WHERE worker_lease.heartbeat < ? OR worker_lease.owner = excluded.owner`,
+        owner, now.Unix(), now.Unix(), now.Add(-LeaseTTL).Unix())
+    if err != nil {
+        return nil, fmt.Errorf("acquiring lease: %w", err)
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        var holder string
+        var heartbeat int64
+        if err := db.QueryRow("SELECT owner, heartbeat FROM worker_lease WHERE id = 1").Scan(&holder, &heartbeat); err != nil {
+            return nil, fmt.Errorf("reading lease: %w", err)
+        }
+        age := now.Sub(time.Unix(heartbeat, 0)).Round(time.Second)
+        return nil, fmt.Errorf("%w: %s, last heartbeat %v ago", ErrLeaseHeld, holder, age)
+    }
+
+    l := &Lease{
+        db:    db,
+        Owner: owner,
+        stop:  make(chan struct{}),
+        done:  make(chan struct{}),
+        lost:  make(chan struct{}),
+    }
+    go l.heartbeat()
+    return l, nil
+}
+
+func (l *Lease) heartbeat() {
+    defer close(l.done)
+    ticker := time.NewTicker(LeaseTTL / 4)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-l.stop:
+            return
+        case now := <-ticker.C:
+            res, err := l.db.Exec("UPDATE worker_lease SET heartbeat = ? WHERE id = 1 AND owner = ?", now.Unix(), l.Owner)
+            if err != nil {
+                log.Printf("Failed to renew worker lease: %v", err)
+                continue
+            }
+            if n, _ := res.RowsAffected(); n == 0 {
+                log.Printf("Worker lease of %s was taken over by another worker", l.Owner)
+                close(l.lost)
+                return
+            }
+        }
+    }
+}
+
+// Lost is closed when another worker takes the lease over, after this one
+// missed its heartbeats for LeaseTTL. The worker must then stop processing.
+func (l *Lease) Lost() <-chan struct{} {
+    return l.lost
+}
+
+// Release stops the heartbeat and frees the lease for the next worker.
+func (l *Lease) Release() error {
+    close(l.stop)
+    <-l.done
+    _, err := l.db.Exec("DELETE FROM worker_lease WHERE id = 1 AND owner = ?", l.Owner)
+    return err
+}
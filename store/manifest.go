@@ -0,0 +1,161 @@
+package store
+
+import (
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/JARS3N/Vis/details"
+)
+
+// FileEntry is a details.xml file of a lot as it was when the lot was last
+// processed. Path is relative to the lot folder.
+type FileEntry struct {
+    Path    string
+    Size    int64
+    ModTime time.Time
+    Hash    string // SHA-256 of the contents
+}
+
+// Manifest maps the relative path of each details.xml file of a lot to its
+// entry.
+type Manifest map[string]FileEntry
+
+// LoadManifests returns the manifest of every lot that has one. A lot
+// recorded with no files has an empty, non-nil manifest.
+func LoadManifests(db *sql.DB) (map[string]Manifest, error) {
+    manifests := make(map[string]Manifest)
+
+    rows, err := db.Query("SELECT lot FROM lot_manifests")
+    if err != nil {
+        return nil, fmt.Errorf("query error: %w", err)
+    }
+    for rows.Next() {
+        var lot string
+        if err := rows.Scan(&lot); err != nil {
+            rows.Close()
+            return nil, fmt.Errorf("scan error: %w", err)
+        }
+        manifests[lot] = make(Manifest)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("rows iteration error: %w", err)
+    }
+
+    rows, err = db.Query("SELECT lot, path, size, mtime, hash FROM manifest_files")
+    if err != nil {
+        return nil, fmt.Errorf("query error: %w", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var lot, mtime string
+        var entry FileEntry
+        if err := rows.Scan(&lot, &entry.Path, &entry.Size, &mtime, &entry.Hash); err != nil {
+            return nil, fmt.Errorf("scan error: %w", err)
+        }
+        entry.ModTime, _ = time.Parse(time.RFC3339Nano, mtime)
+        if manifests[lot] == nil {
+            manifests[lot] = make(Manifest)
+        }
+        manifests[lot][entry.Path] = entry
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("rows iteration error: %w", err)
+    }
+
+    return manifests, nil
+}
+
+// SaveManifest replaces the manifest of a lot.
+func SaveManifest(db *sql.DB, lot string, files []FileEntry) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("DELETE FROM manifest_files WHERE lot = ?", lot); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("INSERT OR REPLACE INTO lot_manifests (lot, scanned) VALUES (?, ?)",
+        lot, time.Now().Format(time.RFC3339)); err != nil {
+        return err
+    }
+    for _, f := range files {
+        if _, err := tx.Exec("INSERT INTO manifest_files (lot, path, size, mtime, hash) VALUES (?, ?, ?, ?, ?)",
+            lot, f.Path, f.Size, f.ModTime.UTC().Format(time.RFC3339Nano), f.Hash); err != nil {
+            return fmt.Errorf("saving %s: %w", f.Path, err)
+        }
+    }
+    return tx.Commit()
+}
+
+// ScanLot lists the details.xml files of a lot folder. Files whose size and
+// modification time match the previous manifest keep their hash; the rest
+// are hashed.
+func ScanLot(dir string, previous Manifest) ([]FileEntry, error) {
+    paths, err := details.GetDetailsXMLFiles(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    files := make([]FileEntry, 0, len(paths))
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+        rel, err := filepath.Rel(dir, path)
+        if err != nil {
+            return nil, err
+        }
+        entry := FileEntry{
+            Path:    filepath.ToSlash(rel),
+            Size:    info.Size(),
+            ModTime: info.ModTime(),
+        }
+
+        if old, ok := previous[entry.Path]; ok && old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) {
+            entry.Hash = old.Hash
+        } else if entry.Hash, err = hashFile(path); err != nil {
+            return nil, err
+        }
+        files = append(files, entry)
+    }
+    return files, nil
+}
+
+// Changed reports whether files were added, removed or rewritten with new
+// contents since the previous manifest.
+func (m Manifest) Changed(files []FileEntry) bool {
+    if len(files) != len(m) {
+        return true
+    }
+    for _, f := range files {
+        old, ok := m[f.Path]
+        if !ok || old.Hash != f.Hash {
+            return true
+        }
+    }
+    return false
+}
+
+func hashFile(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    h := sha256.New()
+    if _, err := io.Copy(h, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// migration upgrades the database by one schema version. Migrations must
+// tolerate databases that were set up by hand before schema_version
+// existed, so they only create what is missing.
+type migration struct {
+    version int
+    name    string
+    apply   func(tx *sql.Tx) error
+}
+
+// migrations are applied in order. Append new ones; never edit or reorder
+// those already released.
+var migrations = []migration{
+    {1, "machine-vision lot index", execAll(
+        "CREATE TABLE IF NOT EXISTS `machine-vision` (dir TEXT, Lot TEXT, result_csv TEXT, result_date TEXT)",
+    )},
+    {2, "lot status and attempts", addStatusColumns},
+    {3, "measurement tables", execAll(
+        `CREATE TABLE IF NOT EXISTS lots (
+    lot TEXT PRIMARY KEY,
+    instrument TEXT,
+    dir TEXT,
+    processed_date TEXT
+)`,
+        `CREATE TABLE IF NOT EXISTS cartridges (
+    id INTEGER PRIMARY KEY,
+    lot TEXT NOT NULL,
+    sn TEXT NOT NULL,
+    type TEXT,
+    instrument TEXT,
+    barcode_error TEXT,
+    well_validation TEXT,
+    result TEXT,
+    result_codes TEXT,
+    UNIQUE (lot, sn)
+)`,
+        `CREATE TABLE IF NOT EXISTS wells (
+    id INTEGER PRIMARY KEY,
+    cartridge_id INTEGER NOT NULL REFERENCES cartridges(id),
+    well TEXT NOT NULL,
+    UNIQUE (cartridge_id, well)
+)`,
+        // Each measurement is one row keyed by the well, its section
+        // (Optical, Spot, Port or Drug) and its name within the section
+        `CREATE TABLE IF NOT EXISTS measurements (
+    well_id INTEGER NOT NULL REFERENCES wells(id),
+    section TEXT NOT NULL,
+    name TEXT NOT NULL,
+    value REAL,
+    text TEXT,
+    PRIMARY KEY (well_id, section, name)
+)`,
+        `CREATE INDEX IF NOT EXISTS idx_lots_date ON lots(processed_date)`,
+        `CREATE INDEX IF NOT EXISTS idx_cartridges_sn ON cartridges(sn)`,
+        `CREATE INDEX IF NOT EXISTS idx_wells_well ON wells(well)`,
+        `CREATE INDEX IF NOT EXISTS idx_measurements_name ON measurements(section, name)`,
+    )},
+    {4, "details.xml manifests", execAll(
+        `CREATE TABLE IF NOT EXISTS lot_manifests (
+    lot TEXT PRIMARY KEY,
+    scanned TEXT
+)`,
+        `CREATE TABLE IF NOT EXISTS manifest_files (
+    lot TEXT NOT NULL,
+    path TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mtime TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    PRIMARY KEY (lot, path)
+)`,
+    )},
+    {5, "worker lease", execAll(
+        // Times are Unix seconds; id is always 1, so there is one lease
+        `CREATE TABLE IF NOT EXISTS worker_lease (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    owner TEXT NOT NULL,
+    acquired INTEGER NOT NULL,
+    heartbeat INTEGER NOT NULL
+)`,
+    )},
+    {6, "run history and file errors", execAll(
+        `CREATE TABLE IF NOT EXISTS runs (
+    id INTEGER PRIMARY KEY,
+    program TEXT NOT NULL,
+    command TEXT,
+    version TEXT,
+    host TEXT,
+    started TEXT NOT NULL,
+    finished TEXT,
+    files INTEGER NOT NULL DEFAULT 0,
+    rows INTEGER NOT NULL DEFAULT 0
+)`,
+        `CREATE TABLE IF NOT EXISTS errors (
+    id INTEGER PRIMARY KEY,
+    run_id INTEGER NOT NULL REFERENCES runs(id),
+    path TEXT NOT NULL,
+    stage TEXT NOT NULL,
+    error TEXT NOT NULL,
+    occurred TEXT NOT NULL
+)`,
+        `CREATE INDEX IF NOT EXISTS idx_errors_path ON errors(path)`,
+        `CREATE INDEX IF NOT EXISTS idx_runs_started ON runs(started)`,
+    )},
+    {7, "cartridges not unique by SN", execAll(
+        // A lot may hold the same SN twice: a cartridge inspected twice,
+        // or several unreadable barcodes. SQLite cannot drop a constraint,
+        // so the table is rebuilt keeping its ids.
+        `CREATE TABLE cartridges_new (
+    id INTEGER PRIMARY KEY,
+    lot TEXT NOT NULL,
+    sn TEXT NOT NULL,
+    type TEXT,
+    instrument TEXT,
+    barcode_error TEXT,
+    well_validation TEXT,
+    result TEXT,
+    result_codes TEXT
+)`,
+        `INSERT INTO cartridges_new (id, lot, sn, type, instrument, barcode_error, well_validation, result, result_codes)
+SELECT id, lot, sn, type, instrument, barcode_error, well_validation, result, result_codes FROM cartridges`,
+        `DROP TABLE cartridges`,
+        `ALTER TABLE cartridges_new RENAME TO cartridges`,
+        `CREATE INDEX IF NOT EXISTS idx_cartridges_lot ON cartridges(lot)`,
+        `CREATE INDEX IF NOT EXISTS idx_cartridges_sn ON cartridges(sn)`,
+    )},
+    {8, "cartridge files and barcodes", execAll(
+        // Cartridges are stored per details.xml file, under the lot folder
+        // the file was found in; the barcode shows the lot it reads
+        `ALTER TABLE cartridges ADD COLUMN barcode TEXT`,
+        `ALTER TABLE cartridges ADD COLUMN file TEXT`,
+        `CREATE INDEX IF NOT EXISTS idx_cartridges_file ON cartridges(file)`,
+    )},
+    {9, "measurement ports", execAll(
+        // Port measurements keep their port in a column of its own, so
+        // both port layouts name a metric alike: section Drug, port A,
+        // name diameter rather than Port_A_diameter. Names stored before
+        // are split where they start with a port; in the Port section
+        // that is an id of one or two characters.
+        `CREATE TABLE measurements_new (
+    well_id INTEGER NOT NULL REFERENCES wells(id),
+    section TEXT NOT NULL,
+    port TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    value REAL,
+    text TEXT,
+    PRIMARY KEY (well_id, section, port, name)
+)`,
+        `INSERT INTO measurements_new (well_id, section, port, name, value, text)
+SELECT well_id, section,
+    CASE WHEN p > 0 THEN substr(n, 1, p - 1) ELSE '' END,
+    CASE WHEN p > 0 THEN substr(n, p + 1) ELSE name END,
+    value, text
+FROM (
+    SELECT *, CASE
+        WHEN section = 'Drug' AND n <> name THEN instr(n, '_')
+        WHEN section = 'Port' AND instr(n, '_') BETWEEN 2 AND 3 THEN instr(n, '_')
+        ELSE 0 END AS p
+    FROM (
+        SELECT *, CASE WHEN section = 'Drug' AND name LIKE 'Port\_%' ESCAPE '\' THEN substr(name, 6) ELSE name END AS n
+        FROM measurements
+    )
+)`,
+        `DROP TABLE measurements`,
+        `ALTER TABLE measurements_new RENAME TO measurements`,
+        `CREATE INDEX IF NOT EXISTS idx_measurements_name ON measurements(section, name)`,
+    )},
+}
+
+// LatestVersion is the schema version this build migrates to.
+func LatestVersion() int {
+    return migrations[len(migrations)-1].version
+}
+
+// Version returns the schema version recorded in the database, or 0 for a
+// database that has never been migrated.
+func Version(db *sql.DB) (int, error) {
+    if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER PRIMARY KEY,
+    name TEXT,
+    applied TEXT
+)`); err != nil {
+        return 0, fmt.Errorf("creating schema_version table: %w", err)
+    }
+    var version int
+    if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+        return 0, fmt.Errorf("reading schema version: %w", err)
+    }
+    return version, nil
+}
+
+// Migrate applies the migrations newer than the recorded schema version,
+// each in its own transaction, and returns the versions applied. Existing
+// rows, such as the lot history in `machine-vision`, are kept. Workers
+// starting together may migrate the same database; each migration is
+// applied by one of them.
+func Migrate(db *sql.DB) ([]int, error) {
+    current, err := Version(db)
+    if err != nil {
+        return nil, err
+    }
+    if current > LatestVersion() {
+        return nil, fmt.Errorf("database schema version %d is newer than this build (%d)", current, LatestVersion())
+    }
+
+    var applied []int
+    for _, m := range migrations {
+        if m.version <= current {
+            continue
+        }
+        ok, err := applyMigration(db, m)
+        if err != nil {
+            return applied, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+        }
+        if ok {
+            applied = append(applied, m.version)
+        }
+    }
+    return applied, nil
+}
+
+// applyMigration applies m unless another connection already has, and
+// reports whether it did.
+func applyMigration(db *sql.DB, m migration) (bool, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    // Take the write lock before reading the version, so a worker
+    // migrating at the same time waits for this one and then finds the
+    // migration applied. An UPDATE takes the lock even when it matches no
+    // rows.
+    if _, err := tx.Exec("UPDATE schema_version SET version = version WHERE version = ?", m.version); err != nil {
+        return false, err
+    }
+    var current int
+    if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+        return false, err
+    }
+    if current >= m.version {
+        return false, nil
+    }
+
+    if err := m.apply(tx); err != nil {
+        return false, err
+    }
+    if _, err := tx.Exec("INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)",
+        m.version, m.name, time.Now().Format(time.RFC3339)); err != nil {
+        return false, err
+    }
+    return true, tx.Commit()
+}
+
+// execAll returns a migration step running each statement in turn.
+func execAll(stmts ...string) func(tx *sql.Tx) error {
+    return func(tx *sql.Tx) error {
+        for _, stmt := range stmts {
+            if _, err := tx.Exec(stmt); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+}
+
+// addStatusColumns adds the status and attempts columns when missing.
+// Lots recorded before they existed were only inserted after a run, so
+// they default to success.
+func addStatusColumns(tx *sql.Tx) error {
+    rows, err := tx.Query("PRAGMA table_info(`machine-vision`)")
+    if err != nil {
+        return fmt.Errorf("query error: %w", err)
+    }
+    columns := make(map[string]bool)
+    for rows.Next() {
+        var cid, notNull, pk int
+        var name, colType string
+        var dflt sql.NullString
+        if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+            rows.Close()
+            return fmt.Errorf("scan error: %w", err)
+        }
+        columns[name] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return fmt.Errorf("rows iteration error: %w", err)
+    }
+
+    if !columns["status"] {
+        if _, err := tx.Exec("ALTER TABLE `machine-vision` ADD COLUMN status TEXT NOT NULL DEFAULT 'success'"); err != nil {
+            return fmt.Errorf("adding status column: %w", err)
+        }
+    }
+    if !columns["attempts"] {
+        if _, err := tx.Exec("ALTER TABLE `machine-vision` ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1"); err != nil {
+            return fmt.Errorf("adding attempts column: %w", err)
+        }
+    }
+    return nil
+}
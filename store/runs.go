@@ -0,0 +1,107 @@
+package store
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "time"
+)
+
+// Run is a row of the runs table, recording one invocation of vis_worker or
+// viswrangler. A nil *Run records nothing, for runs without a database.
+type Run struct {
+    db *sql.DB
+    ID int64
+}
+
+// StartRun records the start of a run. program is the executable and
+// command what it was asked to do.
+func StartRun(db *sql.DB, program, command, version string) (*Run, error) {
+    host, _ := os.Hostname()
+    res, err := db.Exec("INSERT INTO runs (program, command, version, host, started, files, rows) VALUES (?, ?, ?, ?, ?, 0, 0)",
+        program, command, version, host, time.Now().Format(time.RFC3339))
+    if err != nil {
+        return nil, fmt.Errorf("recording run: %w", err)
+    }
+    id, err := res.LastInsertId()
+    if err != nil {
+        return nil, err
+    }
+    return &Run{db: db, ID: id}, nil
+}
+
+// FileError is a file or lot folder that failed, and the stage it failed
+// at, as stored in the errors table.
+type FileError struct {
+    Path  string
+    Stage string
+    Err   string
+}
+
+// Record adds the files read and rows written to the run's totals, and
+// stores the files that failed.
+func (r *Run) Record(files, rows int, errs []FileError) error {
+    if r == nil {
+        return nil
+    }
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("UPDATE runs SET files = files + ?, rows = rows + ? WHERE id = ?", files, rows, r.ID); err != nil {
+        return fmt.Errorf("updating run: %w", err)
+    }
+    now := time.Now().Format(time.RFC3339)
+    for _, e := range errs {
+        if _, err := tx.Exec("INSERT INTO errors (run_id, path, stage, error, occurred) VALUES (?, ?, ?, ?, ?)",
+            r.ID, e.Path, e.Stage, e.Err, now); err != nil {
+            return fmt.Errorf("recording error for %s: %w", e.Path, err)
+        }
+    }
+    return tx.Commit()
+}
+
+// Finish records the end time of the run.
+func (r *Run) Finish() error {
+    if r == nil {
+        return nil
+    }
+    _, err := r.db.Exec("UPDATE runs SET finished = ? WHERE id = ?", time.Now().Format(time.RFC3339), r.ID)
+    return err
+}
+
+// ErrorRecord is a row of the errors table with the run it came from.
+type ErrorRecord struct {
+    Occurred string
+    Program  string
+    Host     string
+    Path     string
+    Stage    string
+    Err      string
+}
+
+// RecentErrors returns up to limit errors, newest first.
+func RecentErrors(db *sql.DB, limit int) ([]ErrorRecord, error) {
+    rows, err := db.Query(`SELECT errors.occurred, runs.program, runs.host, errors.path, errors.stage, errors.error
+FROM errors JOIN runs ON errors.run_id = runs.id
+ORDER BY errors.id DESC LIMIT ?`, limit)
+    if err != nil {
+        return nil, fmt.Errorf("query error: %w", err)
+    }
+    defer rows.Close()
+
+    var records []ErrorRecord
+    for rows.Next() {
+        var r ErrorRecord
+        if err := rows.Scan(&r.Occurred, &r.Program, &r.Host, &r.Path, &r.Stage, &r.Err); err != nil {
+            return nil, fmt.Errorf("scan error: %w", err)
+        }
+        records = append(records, r)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("rows iteration error: %w", err)
+    }
+    return records, nil
+}
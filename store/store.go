@@ -0,0 +1,144 @@
+// Package store writes parsed lots into normalized SQLite tables, so the
+// measurements can be queried with SQL instead of read back from CSVs.
+package store
+
+import (
+    "database/sql"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/JARS3N/Vis/details"
+)
+
+// Lot identifies a lot folder and when it was processed.
+type Lot struct {
+    Lot        string
+    Instrument string
+    Dir        string
+    Date       string // YYYY-MM-DD
+}
+
+// Cartridge is one details.xml file of a lot folder with its parsed wells.
+// Its barcode may name another lot, or none when it could not be read.
+type Cartridge struct {
+    File           string
+    SN             string
+    Type           string
+    Instrument     string
+    Barcode        string
+    BarcodeError   string
+    WellValidation string
+    Wells          []details.WellResult
+}
+
+// SaveLot replaces everything stored for the lot folder with its
+// cartridges. Each file is a cartridge of its own, so a cartridge inspected
+// twice or several unreadable barcodes give several cartridges with the
+// same SN.
+func SaveLot(db *sql.DB, lot Lot, cartridges []Cartridge) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := deleteLot(tx, lot.Lot); err != nil {
+        return fmt.Errorf("clearing lot %s: %w", lot.Lot, err)
+    }
+
+    if _, err := tx.Exec("INSERT INTO lots (lot, instrument, dir, processed_date) VALUES (?, ?, ?, ?)",
+        lot.Lot, lot.Instrument, lot.Dir, lot.Date); err != nil {
+        return fmt.Errorf("inserting lot %s: %w", lot.Lot, err)
+    }
+
+    for _, c := range cartridges {
+        if err := insertCartridge(tx, lot.Lot, c); err != nil {
+            return fmt.Errorf("inserting cartridge %s of lot %s: %w", c.File, lot.Lot, err)
+        }
+    }
+
+    return tx.Commit()
+}
+
+// insertCartridge stores the cartridge under the lot folder it was found
+// in. A well listed twice in the file is stored once, with the values read
+// last, as in its CSV row.
+func insertCartridge(tx *sql.Tx, lot string, c Cartridge) error {
+    res, err := tx.Exec("INSERT INTO cartridges (lot, sn, type, instrument, barcode, barcode_error, well_validation, file) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+        lot, c.SN, c.Type, c.Instrument, c.Barcode, c.BarcodeError, c.WellValidation, c.File)
+    if err != nil {
+        return err
+    }
+    cartridgeID, err := res.LastInsertId()
+    if err != nil {
+        return err
+    }
+
+    wellIDs := make(map[string]int64)
+    for _, w := range c.Wells {
+        if w.Well == "" {
+            continue
+        }
+        wellID, ok := wellIDs[w.Well]
+        if !ok {
+            res, err := tx.Exec("INSERT INTO wells (cartridge_id, well) VALUES (?, ?)", cartridgeID, w.Well)
+            if err != nil {
+                return fmt.Errorf("inserting well %s: %w", w.Well, err)
+            }
+            if wellID, err = res.LastInsertId(); err != nil {
+                return err
+            }
+            wellIDs[w.Well] = wellID
+        }
+
+        insert := func(section, port, name, text string) error {
+            var value sql.NullFloat64
+            if f, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+                value = sql.NullFloat64{Float64: f, Valid: true}
+            }
+            if _, err := tx.Exec("INSERT OR REPLACE INTO measurements (well_id, section, port, name, value, text) VALUES (?, ?, ?, ?, ?, ?)",
+                wellID, section, port, name, value, text); err != nil {
+                return fmt.Errorf("inserting %s %s of well %s: %w", section, name, w.Well, err)
+            }
+            return nil
+        }
+        for _, m := range w.Optical {
+            if err := insert("Optical", "", m.Name, m.Value); err != nil {
+                return err
+            }
+        }
+        for _, m := range w.Spot {
+            if err := insert("Spot", "", m.Name, m.Value); err != nil {
+                return err
+            }
+        }
+        // Both port layouts store the metric alone, with its port
+        section := "Port"
+        if w.PortLayout == details.DrugLayout {
+            section = "Drug"
+        }
+        for _, p := range w.Ports {
+            if err := insert(section, p.Port, p.Name, p.Value); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// deleteLot removes the rows left by an earlier run of the lot.
+func deleteLot(tx *sql.Tx, lot string) error {
+    stmts := []string{
+        "DELETE FROM measurements WHERE well_id IN (SELECT wells.id FROM wells JOIN cartridges ON wells.cartridge_id = cartridges.id WHERE cartridges.lot = ?)",
+        "DELETE FROM wells WHERE cartridge_id IN (SELECT id FROM cartridges WHERE lot = ?)",
+        "DELETE FROM cartridges WHERE lot = ?",
+        "DELETE FROM lots WHERE lot = ?",
+    }
+    for _, stmt := range stmts {
+        if _, err := tx.Exec(stmt, lot); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+    "database/sql"
+    "strings"
+    "testing"
+
+    _ "github.com/mattn/go-sqlite3"
+
+    "github.com/JARS3N/Vis/details"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+    t.Helper()
+    db, err := sql.Open("sqlite3", ":memory:")
+    if err != nil {
+        t.Fatal(err)
+    }
+    db.SetMaxOpenConns(1)
+    t.Cleanup(func() { db.Close() })
+    if _, err := Migrate(db); err != nil {
+        t.Fatalf("Migrate: %v", err)
+    }
+    return db
+}
+
+func TestSaveLotCartridgesByFile(t *testing.T) {
+    db := openTestDB(t)
+
+    cartridge := func(file, sn string, wells ...string) Cartridge {
+        c := Cartridge{File: file, SN: sn}
+        for _, w := range wells {
+            c.Wells = append(c.Wells, details.WellResult{
+                Well:    w,
+                Optical: []details.Measurement{{Name: "Area", Value: "12"}},
+            })
+        }
+        return c
+    }
+    cartridges := []Cartridge{
+        // The same SN in two files, with different wells
+        cartridge("r1/details.xml", "42", "A01"),
+        cartridge("r2/details.xml", "42", "A02"),
+        // Two files whose barcodes could not be read
+        cartridge("r3/details.xml", "", "A01"),
+        cartridge("r4/details.xml", "", "A01"),
+        // A well listed twice is stored once
+        cartridge("r5/details.xml", "43", "A01", "A01"),
+    }
+    if err := SaveLot(db, Lot{Lot: "B12345", Date: "2024-07-16"}, cartridges); err != nil {
+        t.Fatalf("SaveLot: %v", err)
+    }
+
+    var count, wells, measurements int
+    db.QueryRow("SELECT COUNT(*) FROM cartridges WHERE lot = 'B12345'").Scan(&count)
+    db.QueryRow("SELECT COUNT(*) FROM wells").Scan(&wells)
+    db.QueryRow("SELECT COUNT(*) FROM measurements").Scan(&measurements)
+    if count != 5 || wells != 5 || measurements != 5 {
+        t.Errorf("stored %d cartridges, %d wells, %d measurements; want 5, 5, 5", count, wells, measurements)
+    }
+
+    // Saving again replaces the lot
+    if err := SaveLot(db, Lot{Lot: "B12345", Date: "2024-07-17"}, cartridges[:1]); err != nil {
+        t.Fatalf("SaveLot again: %v", err)
+    }
+    var file string
+    db.QueryRow("SELECT COUNT(*), MAX(file) FROM cartridges").Scan(&count, &file)
+    if count != 1 || file != "r1/details.xml" {
+        t.Errorf("stored %d cartridges (%s) after saving again, want 1 (r1/details.xml)", count, file)
+    }
+}
+
+func TestSaveLotPorts(t *testing.T) {
+    db := openTestDB(t)
+
+    ports := []details.PortMeasurement{{Port: "A", Name: "diameter", Value: "0.41"}, {Name: "Count", Value: "4"}}
+    cartridges := []Cartridge{
+        {File: "r1/details.xml", SN: "1", Wells: []details.WellResult{{Well: "A01", Ports: ports}}},
+        {File: "r2/details.xml", SN: "2", Wells: []details.WellResult{{Well: "A01", Ports: ports, PortLayout: details.DrugLayout}}},
+    }
+    if err := SaveLot(db, Lot{Lot: "C54321"}, cartridges); err != nil {
+        t.Fatalf("SaveLot: %v", err)
+    }
+
+    // Both layouts name the metric alike
+    rows, err := db.Query("SELECT section, port, name FROM measurements ORDER BY section, port")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    var got []string
+    for rows.Next() {
+        var section, port, name string
+        if err := rows.Scan(&section, &port, &name); err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, section+"/"+port+"/"+name)
+    }
+    want := []string{"Drug//Count", "Drug/A/diameter", "Port//Count", "Port/A/diameter"}
+    if strings.Join(got, " ") != strings.Join(want, " ") {
+        t.Errorf("stored %v, want %v", got, want)
+    }
+}
@@ -0,0 +1,109 @@
+// Package table combines the rows parsed from inspection files into the
+// flat tables written as output.
+package table
+
+import (
+    "sort"
+
+    "github.com/JARS3N/Vis/barcode"
+    "github.com/JARS3N/Vis/details"
+)
+
+// BindRows gives every row the union of the keys of all rows, with "" for
+// the missing values.
+func BindRows(tables []map[string]string) []map[string]string {
+    combinedTable := make([]map[string]string, len(tables))
+
+    allKeys := make(map[string]struct{})
+    for _, table := range tables {
+        for key := range table {
+            allKeys[key] = struct{}{}
+        }
+    }
+
+    var keys []string
+    for key := range allKeys {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for i, table := range tables {
+        combinedRow := make(map[string]string)
+        for _, key := range keys {
+            if value, ok := table[key]; ok {
+                combinedRow[key] = value
+            } else {
+                combinedRow[key] = ""
+            }
+        }
+        combinedTable[i] = combinedRow
+    }
+
+    return combinedTable
+}
+
+// Join prefixes every row with the barcode columns.
+func Join(barcodeTable map[string]string, combinedTable []map[string]string) []map[string]string {
+    finalTable := make([]map[string]string, len(combinedTable))
+
+    for i, row := range combinedTable {
+        combinedRow := make(map[string]string)
+        for k, v := range barcodeTable {
+            combinedRow[k] = v
+        }
+        for k, v := range row {
+            combinedRow[k] = v
+        }
+        finalTable[i] = combinedRow
+    }
+
+    return finalTable
+}
+
+// CartridgeKey identifies a cartridge across details.xml and context.xml.
+type CartridgeKey struct {
+    Lot string
+    SN  string
+}
+
+// JoinContext adds the Result and ResultCodes of each row's cartridge to the
+// row in place, matching on Lot and SN. Rows without a context get empty
+// values so every row has the same columns. It returns the cartridges that
+// have details but no context, and those with a context but no details.
+func JoinContext(rows []map[string]string, contexts []details.ContextResult) (missingContext, missingDetails []CartridgeKey) {
+    byKey := make(map[CartridgeKey]details.ContextResult)
+    for _, ctx := range contexts {
+        byKey[CartridgeKey{Lot: ctx.Lot, SN: ctx.SN}] = ctx
+    }
+
+    seen := make(map[CartridgeKey]bool)
+    for _, row := range rows {
+        key := CartridgeKey{Lot: row["Lot"], SN: row["SN"]}
+        ctx, ok := byKey[key]
+        if !ok && !seen[key] {
+            missingContext = append(missingContext, key)
+        }
+        seen[key] = true
+        row["Result"] = ctx.Result
+        row["ResultCodes"] = ctx.ResultCodes
+    }
+
+    for key := range byKey {
+        if !seen[key] {
+            missingDetails = append(missingDetails, key)
+        }
+    }
+
+    sortKeys(missingContext)
+    sortKeys(missingDetails)
+    return missingContext, missingDetails
+}
+
+func sortKeys(keys []CartridgeKey) {
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].Lot != keys[j].Lot {
+            return keys[i].Lot < keys[j].Lot
+        }
+        return barcode.CompareSN(keys[i].SN, keys[j].SN) < 0
+    })
+}
@@ -0,0 +1,194 @@
+package wrangler
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+
+    "github.com/JARS3N/Vis/csvout"
+    "github.com/JARS3N/Vis/jsonout"
+    "github.com/JARS3N/Vis/parquetout"
+)
+
+// Output layouts.
+const (
+    LayoutPerLot      = "per-lot"     // one file per lot in OutputDir
+    LayoutCombined    = "combined"    // one file holding every lot
+    LayoutPartitioned = "partitioned" // Instrument=<I>/Lot=<L>/ folders, as read by arrow and DuckDB
+)
+
+// DefaultName is the file name template of the outputs, giving
+// <Lot>_MV.csv. The tokens {Lot}, {Instrument} and {Date} (the day of the
+// run, as 2006-01-02) are replaced, and the extension of the format is
+// added. In a combined file holding several lots or instruments, {Lot} or
+// {Instrument} is "combined".
+const DefaultName = "{Lot}_MV"
+
+// combinedToken stands for several lots or instruments in a file name.
+const combinedToken = "combined"
+
+var nameTokenRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+// extensions end the file names of each format.
+var extensions = map[string]string{
+    FormatCSV:     ".csv",
+    FormatParquet: ".parquet",
+    FormatJSONL:   ".jsonl",
+    FormatJSON:    ".json",
+    FormatLong:    "_long.csv",
+}
+
+// CheckName reports whether name is a usable file name template.
+func CheckName(name string) error {
+    if strings.ContainsAny(name, `/\`) {
+        return fmt.Errorf("file name template %q must not contain a path separator", name)
+    }
+    for _, token := range nameTokenRe.FindAllString(name, -1) {
+        switch token {
+        case "{Lot}", "{Instrument}", "{Date}":
+        default:
+            return fmt.Errorf("unknown token %s in file name template %q, want {Lot}, {Instrument} or {Date}", token, name)
+        }
+    }
+    if strings.ContainsAny(nameTokenRe.ReplaceAllString(name, ""), "{}") {
+        return fmt.Errorf("unbalanced braces in file name template %q", name)
+    }
+    return nil
+}
+
+// outputGroup is what goes into one output file: a single lot, or every
+// lot when the layout is combined.
+type outputGroup struct {
+    lots       []string // sorted
+    rows       map[string][]map[string]string
+    checks     map[string][]CartridgeCheck
+    cartridges map[string][]jsonout.Cartridge
+}
+
+// groupOutputs splits the rows into the files of opts.Layout, in lot
+// order.
+func groupOutputs(opts Options, rows []map[string]string, checks map[string][]CartridgeCheck, cartridges map[string][]jsonout.Cartridge) []outputGroup {
+    byLot := make(map[string][]map[string]string)
+    for _, row := range rows {
+        byLot[row["Lot"]] = append(byLot[row["Lot"]], row)
+    }
+    var lots []string
+    for lot := range byLot {
+        lots = append(lots, lot)
+    }
+    sort.Strings(lots)
+
+    group := func(lots ...string) outputGroup {
+        return outputGroup{lots: lots, rows: byLot, checks: checks, cartridges: cartridges}
+    }
+    if opts.Layout == LayoutCombined {
+        return []outputGroup{group(lots...)}
+    }
+    var groups []outputGroup
+    for _, lot := range lots {
+        groups = append(groups, group(lot))
+    }
+    return groups
+}
+
+// lotRows returns the rows of the group, lot by lot.
+func (g outputGroup) lotRows() []map[string]string {
+    var rows []map[string]string
+    for _, lot := range g.lots {
+        rows = append(rows, g.rows[lot]...)
+    }
+    return rows
+}
+
+// instrument returns the instrument of the lot's rows, "unknown" when they
+// have none.
+func (g outputGroup) instrument(lot string) string {
+    if instrument := g.rows[lot][0]["Instrument"]; instrument != "" {
+        return instrument
+    }
+    return "unknown"
+}
+
+// tokens returns the values of the file name tokens.
+func (g outputGroup) tokens(date string) map[string]string {
+    lot, instrument := combinedToken, combinedToken
+    if len(g.lots) == 1 {
+        lot = g.lots[0]
+    }
+    instruments := make(map[string]bool)
+    for _, l := range g.lots {
+        instruments[g.instrument(l)] = true
+    }
+    if len(instruments) == 1 {
+        instrument = g.instrument(g.lots[0])
+    }
+    return map[string]string{"{Lot}": lot, "{Instrument}": instrument, "{Date}": date}
+}
+
+// path returns the file the group is written to.
+func (g outputGroup) path(opts Options, date string) string {
+    tokens := g.tokens(date)
+
+    dir := opts.OutputDir
+    if opts.Layout == LayoutPartitioned {
+        dir = filepath.Join(dir, "Instrument="+tokens["{Instrument}"], "Lot="+tokens["{Lot}"])
+    }
+
+    name := nameTokenRe.ReplaceAllStringFunc(opts.Name, func(token string) string {
+        return tokens[token]
+    })
+    return filepath.Join(dir, name+extensions[opts.Format])
+}
+
+// writeGroup writes a group in opts.Format to outputFilePath and returns
+// the keys the schema does not name, for CSV and Parquet. A partitioned
+// Parquet dataset leaves the Instrument and Lot columns to the folder
+// names, which arrow and DuckDB read back as columns; the other formats
+// keep them, so each file still reads on its own.
+func writeGroup(opts Options, g outputGroup, outputFilePath string) ([]string, error) {
+    if opts.Layout == LayoutPartitioned {
+        if err := os.MkdirAll(filepath.Dir(outputFilePath), os.ModePerm); err != nil {
+            return nil, err
+        }
+    }
+
+    switch opts.Format {
+    case FormatJSONL:
+        var cartridges []jsonout.Cartridge
+        for _, lot := range g.lots {
+            cartridges = append(cartridges, g.cartridges[lot]...)
+        }
+        return nil, jsonout.WriteLines(outputFilePath, cartridges)
+    case FormatJSON:
+        var docs []jsonout.Lot
+        for _, lot := range g.lots {
+            docs = append(docs, jsonout.Lot{
+                Lot:        lot,
+                Instrument: g.rows[lot][0]["Instrument"],
+                Cartridges: g.cartridges[lot],
+            })
+        }
+        if len(docs) == 1 {
+            return nil, jsonout.WriteDocument(outputFilePath, docs[0])
+        }
+        return nil, jsonout.WriteDocuments(outputFilePath, docs)
+    case FormatLong:
+        var rows []csvout.LongRow
+        for _, lot := range g.lots {
+            rows = append(rows, longRows(g.checks[lot])...)
+        }
+        return nil, csvout.WriteLong(outputFilePath, rows)
+    }
+
+    headers, rows, unknown := schemaFor(opts).Apply(g.lotRows())
+    if opts.Format == FormatParquet {
+        if opts.Layout == LayoutPartitioned {
+            headers = withoutColumns(headers, "Instrument", "Lot")
+        }
+        return unknown, parquetout.Write(outputFilePath, headers, rows)
+    }
+    return unknown, csvout.WriteColumns(outputFilePath, headers, rows)
+}
@@ -0,0 +1,575 @@
+// Package wrangler turns the details.xml and context.xml files written by
+// the machine vision software into per-lot CSV tables. It ties together the
+// details, barcode, plate, table and csvout packages and is the library
+// behind viswrangler and vis_worker.
+package wrangler
+
+import (
+    "fmt"
+    "path/filepath"
+    "regexp"
+    "runtime"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/JARS3N/Vis/barcode"
+    "github.com/JARS3N/Vis/csvout"
+    "github.com/JARS3N/Vis/details"
+    "github.com/JARS3N/Vis/jsonout"
+    "github.com/JARS3N/Vis/plate"
+    "github.com/JARS3N/Vis/store"
+    "github.com/JARS3N/Vis/table"
+)
+
+// Version is the release of the wrangler, reported by viswrangler and
+// recorded with each run.
+const (
+    Version     = "2.2"
+    VersionDate = "2024-07-16"
+)
+
+// Stages at which a file can fail to parse.
+const (
+    StageDetails = "details" // reading details.xml
+    StageBarcode = "barcode" // the barcode was flagged as invalid
+    StageResults = "results" // parsing the Results table
+    StageContext = "context" // reading context.xml
+    StageRun     = "run"     // the run itself failed, e.g. writing a CSV
+)
+
+// FileError records a file that could not be parsed, and where it failed.
+type FileError struct {
+    File  string
+    Stage string
+    Err   error
+}
+
+func (e FileError) Error() string {
+    return fmt.Sprintf("%s (%s): %v", e.File, e.Stage, e.Err)
+}
+
+func (e FileError) Unwrap() error {
+    return e.Err
+}
+
+// StoreErrors converts the files a run failed on for the errors table.
+func StoreErrors(errs []FileError) []store.FileError {
+    out := make([]store.FileError, 0, len(errs))
+    for _, e := range errs {
+        out = append(out, store.FileError{Path: e.File, Stage: e.Stage, Err: e.Err.Error()})
+    }
+    return out
+}
+
+// StoreCartridges converts the parsed cartridges for the measurement
+// tables.
+func StoreCartridges(checks []CartridgeCheck) []store.Cartridge {
+    out := make([]store.Cartridge, 0, len(checks))
+    for _, c := range checks {
+        out = append(out, store.Cartridge{
+            File:           c.File,
+            SN:             c.SN,
+            Type:           c.Type,
+            Instrument:     c.Instrument,
+            Barcode:        c.Barcode,
+            BarcodeError:   c.BarcodeError,
+            WellValidation: c.Check.String(),
+            Wells:          c.Results,
+        })
+    }
+    return out
+}
+
+// Output formats of the well rows.
+const (
+    FormatCSV     = "csv"
+    FormatParquet = "parquet"
+    FormatJSONL   = "jsonl" // one cartridge per line
+    FormatJSON    = "json"  // one nested document per lot
+    FormatLong    = "long"  // one CSV row per measurement
+)
+
+// Options controls a Run.
+type Options struct {
+    SearchDir string
+    OutputDir string
+    Jobs      int
+    Context   bool          // also write <Lot>_context.csv
+    Join      bool          // add context Result and ResultCodes to well rows
+    Files     []string      // details.xml files; found under SearchDir when nil
+    Format    string        // FormatCSV when empty
+    Layout    string        // LayoutPerLot when empty
+    Name      string        // file name template; DefaultName when empty
+    Schema    csvout.Schema // columns of CSV and Parquet files; csvout.DefaultSchema when empty
+}
+
+// Result describes what a Run read and wrote.
+type Result struct {
+    Files          int
+    Rows           int
+    Checks         []CartridgeCheck
+    Errors         []FileError
+    MissingContext []table.CartridgeKey
+    MissingDetails []table.CartridgeKey
+    Outputs        []string // CSV files written, in order
+    UnknownColumns []string // keys the schema does not name, sorted
+}
+
+// CartridgeCheck is one parsed details.xml file: its barcode, its wells
+// and their validation outcome.
+type CartridgeCheck struct {
+    File         string
+    Lot          string
+    SN           string
+    Type         string
+    Instrument   string
+    Barcode      string
+    Wells        int
+    BarcodeError string
+    Check        plate.WellCheck
+    Results      []details.WellResult // sorted by well
+}
+
+// DefaultJobs sizes the worker pool. Parsing is mostly waiting on the
+// network drive, so a few workers per CPU keep it busy without opening
+// thousands of files at once.
+func DefaultJobs() int {
+    jobs := runtime.NumCPU() * 2
+    if jobs < 4 {
+        jobs = 4
+    }
+    if jobs > 32 {
+        jobs = 32
+    }
+    return jobs
+}
+
+// Run parses every details.xml file under opts.SearchDir and writes one
+// <Lot>_MV.csv per lot to opts.OutputDir, or the format, layout and file
+// names set by opts. Files that fail to parse are listed in Result.Errors;
+// the returned error is for failures that stop the run, such as an
+// unreadable search directory or a failed write.
+func Run(opts Options) (Result, error) {
+    var result Result
+
+    detailsFiles := opts.Files
+    if detailsFiles == nil {
+        files, err := details.GetDetailsXMLFiles(opts.SearchDir)
+        if err != nil {
+            return result, fmt.Errorf("finding details.xml files: %w", err)
+        }
+        detailsFiles = files
+    }
+    result.Files = len(detailsFiles)
+
+    switch opts.Format {
+    case "":
+        opts.Format = FormatCSV
+    case FormatCSV, FormatParquet, FormatJSONL, FormatJSON, FormatLong:
+    default:
+        return result, fmt.Errorf("unknown output format %q", opts.Format)
+    }
+    switch opts.Layout {
+    case "":
+        opts.Layout = LayoutPerLot
+    case LayoutPerLot, LayoutCombined, LayoutPartitioned:
+    default:
+        return result, fmt.Errorf("unknown output layout %q", opts.Layout)
+    }
+    if opts.Name == "" {
+        opts.Name = DefaultName
+    }
+    if err := CheckName(opts.Name); err != nil {
+        return result, err
+    }
+
+    jobs := opts.Jobs
+    if jobs < 1 {
+        jobs = DefaultJobs()
+    }
+    allCombinedTables, checks, errs := ProcessFiles(detailsFiles, jobs)
+    result.Rows = len(allCombinedTables)
+    result.Checks = checks
+    result.Errors = errs
+
+    var contexts []details.ContextResult
+    if opts.Context || opts.Join {
+        var err error
+        var contextErrs []FileError
+        contexts, contextErrs, err = LoadContexts(opts.SearchDir)
+        result.Errors = append(result.Errors, contextErrs...)
+        if err != nil {
+            return result, err
+        }
+    }
+
+    // Merge the regression results onto the well rows by Lot and SN
+    if opts.Join {
+        result.MissingContext, result.MissingDetails = table.JoinContext(allCombinedTables, contexts)
+    }
+
+    checksByLot := make(map[string][]CartridgeCheck)
+    for _, c := range checks {
+        checksByLot[c.Lot] = append(checksByLot[c.Lot], c)
+    }
+
+    // The JSON formats are built from the parsed cartridges rather than
+    // the flattened rows
+    var cartridges map[string][]jsonout.Cartridge
+    if opts.Format == FormatJSONL || opts.Format == FormatJSON {
+        cartridges = cartridgesByLot(checks, contexts, opts.Join)
+    }
+
+    // If there are any combined tables, save them
+    unknownColumns := make(map[string]bool)
+    if len(allCombinedTables) > 0 {
+        date := time.Now().Format("2006-01-02")
+        groups := groupOutputs(opts, allCombinedTables, checksByLot, cartridges)
+
+        // A template without {Lot} would write several lots to one file
+        paths := make([]string, len(groups))
+        written := make(map[string]bool)
+        for i, g := range groups {
+            paths[i] = g.path(opts, date)
+            if written[paths[i]] {
+                return result, fmt.Errorf("file name template %q gives %s for more than one lot", opts.Name, paths[i])
+            }
+            written[paths[i]] = true
+        }
+
+        for i, g := range groups {
+            outputFilePath := paths[i]
+            unknown, err := writeGroup(opts, g, outputFilePath)
+            if err != nil {
+                return result, fmt.Errorf("writing %s file: %w", opts.Format, err)
+            }
+            result.Outputs = append(result.Outputs, outputFilePath)
+            for _, k := range unknown {
+                unknownColumns[k] = true
+            }
+        }
+    }
+    for k := range unknownColumns {
+        result.UnknownColumns = append(result.UnknownColumns, k)
+    }
+    sort.Strings(result.UnknownColumns)
+
+    if opts.Context {
+        outputs, err := writeContextCSVs(opts.OutputDir, contexts)
+        result.Outputs = append(result.Outputs, outputs...)
+        if err != nil {
+            return result, err
+        }
+    }
+
+    return result, nil
+}
+
+// schemaFor returns the schema of the wide files: opts.Schema, or the
+// built-in one with the context columns after Lot when they are joined.
+func schemaFor(opts Options) csvout.Schema {
+    if len(opts.Schema.Columns) > 0 {
+        return opts.Schema
+    }
+    schema := csvout.DefaultSchema
+    if opts.Schema.Extras != "" {
+        schema.Extras = opts.Schema.Extras
+    }
+    if opts.Join {
+        var columns []string
+        for _, c := range schema.Columns {
+            columns = append(columns, c)
+            if c == "Lot" {
+                columns = append(columns, "Result", "ResultCodes")
+            }
+        }
+        schema.Columns = columns
+    }
+    return schema
+}
+
+// longRows lists the measurements of the cartridges, one per row, by SN
+// and source file, and by well in the order the Results table gives them.
+func longRows(checks []CartridgeCheck) []csvout.LongRow {
+    sorted := append([]CartridgeCheck(nil), checks...)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        if c := barcode.CompareSN(sorted[i].SN, sorted[j].SN); c != 0 {
+            return c < 0
+        }
+        return sorted[i].File < sorted[j].File
+    })
+
+    var rows []csvout.LongRow
+    for _, c := range sorted {
+        for _, w := range c.Results {
+            add := func(section, name, value string) {
+                metric, unit := splitUnit(name)
+                rows = append(rows, csvout.LongRow{
+                    Lot:        c.Lot,
+                    SN:         c.SN,
+                    Type:       c.Type,
+                    Well:       w.Well,
+                    Section:    section,
+                    Metric:     metric,
+                    Value:      value,
+                    Unit:       unit,
+                    SourceFile: c.File,
+                })
+            }
+            for _, m := range w.Optical {
+                add("Optical", m.Name, m.Value)
+            }
+            for _, m := range w.Spot {
+                add("Spot", m.Name, m.Value)
+            }
+            // Both port layouts name the metric Port_<n>_<name>, so it
+            // matches across them
+            section := "Port"
+            if w.PortLayout == details.DrugLayout {
+                section = "Drug"
+            }
+            for _, p := range w.Ports {
+                name := p.Name
+                if p.Port != "" {
+                    name = "Port_" + p.Port + "_" + p.Name
+                }
+                add(section, name, p.Value)
+            }
+        }
+    }
+    return rows
+}
+
+// unitRe matches a unit given in brackets at the end of a measurement
+// name, as in Diameter_(um).
+var unitRe = regexp.MustCompile(`^(.*?)_?[(\[]([^()\[\]]+)[)\]]$`)
+
+// splitUnit separates the unit from a measurement name. The unit is empty
+// when the name carries none.
+func splitUnit(name string) (string, string) {
+    if m := unitRe.FindStringSubmatch(name); m != nil && m[1] != "" {
+        return m[1], m[2]
+    }
+    return name, ""
+}
+
+// cartridgesByLot converts the parsed cartridges for JSON output, sorted by
+// SN within each lot. With join they carry their context.xml results.
+func cartridgesByLot(checks []CartridgeCheck, contexts []details.ContextResult, join bool) map[string][]jsonout.Cartridge {
+    byKey := make(map[table.CartridgeKey]details.ContextResult)
+    for _, ctx := range contexts {
+        byKey[table.CartridgeKey{Lot: ctx.Lot, SN: ctx.SN}] = ctx
+    }
+
+    sorted := append([]CartridgeCheck(nil), checks...)
+    sort.Slice(sorted, func(i, j int) bool {
+        if sorted[i].Lot != sorted[j].Lot {
+            return sorted[i].Lot < sorted[j].Lot
+        }
+        return barcode.CompareSN(sorted[i].SN, sorted[j].SN) < 0
+    })
+
+    byLot := make(map[string][]jsonout.Cartridge)
+    for _, c := range sorted {
+        cartridge := jsonout.Cartridge{
+            Lot:            c.Lot,
+            SN:             c.SN,
+            Type:           c.Type,
+            Instrument:     c.Instrument,
+            Barcode:        c.Barcode,
+            BarcodeError:   c.BarcodeError,
+            SourceFile:     c.File,
+            WellValidation: c.Check.String(),
+            Wells:          make([]jsonout.Well, len(c.Results)),
+        }
+        if join {
+            ctx := byKey[table.CartridgeKey{Lot: c.Lot, SN: c.SN}]
+            cartridge.Result = ctx.Result
+            cartridge.ResultCodes = ctx.ResultCodes
+        }
+        for i, w := range c.Results {
+            cartridge.Wells[i] = jsonout.NewWell(w)
+        }
+        byLot[c.Lot] = append(byLot[c.Lot], cartridge)
+    }
+    return byLot
+}
+
+// withoutColumns returns the header without the given columns.
+func withoutColumns(headers []string, keys ...string) []string {
+    var out []string
+    for _, h := range headers {
+        if !slices.Contains(keys, h) {
+            out = append(out, h)
+        }
+    }
+    return out
+}
+
+// ProcessFiles parses the details.xml files on a pool of jobs workers and
+// returns the rows of every cartridge, its well check and the files that
+// failed.
+func ProcessFiles(detailsFiles []string, jobs int) ([]map[string]string, []CartridgeCheck, []FileError) {
+    allCombinedTables := make([]map[string]string, 0)
+    // The buffer lets workers run ahead of the collector by one result
+    // each; beyond that they block until results are consumed.
+    fileChan := make(chan string)
+    resultChan := make(chan []map[string]string, jobs)
+    var wg sync.WaitGroup
+
+    var mu sync.Mutex
+    var checks []CartridgeCheck
+    var errs []FileError
+    fail := func(file, stage string, err error) {
+        mu.Lock()
+        errs = append(errs, FileError{File: file, Stage: stage, Err: err})
+        mu.Unlock()
+    }
+
+    processFile := func(file string) {
+        code, results, err := details.ExtractDetailsFromXML(file)
+        if err != nil {
+            fail(file, StageDetails, err)
+            return
+        }
+
+        // Process the barcode into a table
+        barcodeTable := barcode.Details(code)
+        if msg := barcodeTable["BarcodeError"]; msg != "" {
+            fail(file, StageBarcode, fmt.Errorf("%s", msg))
+        }
+
+        // Parse the well cells of the results table
+        wells, err := details.ParseResults(results)
+        if err != nil {
+            fail(file, StageResults, err)
+            return
+        }
+
+        sort.Slice(wells, func(i, j int) bool {
+            return wells[i].Well < wells[j].Well
+        })
+
+        // Flatten each well into an individual row
+        tables := make([]map[string]string, len(wells))
+        wellNames := make([]string, len(wells))
+        for i, well := range wells {
+            tables[i] = well.Row()
+            wellNames[i] = well.Well
+        }
+
+        // Check the wells against the plate geometry of the instrument
+        check := plate.ValidateWells(barcodeTable["Instrument"], wellNames)
+        barcodeTable["WellValidation"] = check.String()
+        mu.Lock()
+        checks = append(checks, CartridgeCheck{
+            File:         file,
+            Lot:          barcodeTable["Lot"],
+            SN:           barcodeTable["SN"],
+            Type:         barcodeTable["Type"],
+            Instrument:   barcodeTable["Instrument"],
+            Barcode:      strings.TrimSpace(code),
+            Wells:        len(wells),
+            BarcodeError: barcodeTable["BarcodeError"],
+            Check:        check,
+            Results:      wells,
+        })
+        mu.Unlock()
+
+        // Combine all rows into one table
+        combinedTable := table.BindRows(tables)
+
+        // Sort the combined table by the Well column
+        sort.Slice(combinedTable, func(i, j int) bool {
+            return combinedTable[i]["Well"] < combinedTable[j]["Well"]
+        })
+
+        // Join barcode table with the results table
+        finalTable := table.Join(barcodeTable, combinedTable)
+
+        // Send the final table to the result channel
+        resultChan <- finalTable
+    }
+
+    for i := 0; i < jobs; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for file := range fileChan {
+                processFile(file)
+            }
+        }()
+    }
+
+    go func() {
+        for _, file := range detailsFiles {
+            fileChan <- file
+        }
+        close(fileChan)
+    }()
+
+    // Close the result channel when all files are processed
+    go func() {
+        wg.Wait()
+        close(resultChan)
+    }()
+
+    // Collect all results from the result channel
+    for result := range resultChan {
+        allCombinedTables = append(allCombinedTables, result...)
+    }
+
+    return allCombinedTables, checks, errs
+}
+
+// LoadContexts parses every context.xml file under searchDir.
+func LoadContexts(searchDir string) ([]details.ContextResult, []FileError, error) {
+    contextFiles, err := details.GetContextXMLFiles(searchDir)
+    if err != nil {
+        return nil, nil, fmt.Errorf("finding context.xml files: %w", err)
+    }
+
+    var contexts []details.ContextResult
+    var errs []FileError
+    for _, file := range contextFiles {
+        ctx, err := details.ExtractContextFromXML(file)
+        if err != nil {
+            errs = append(errs, FileError{File: file, Stage: StageContext, Err: err})
+            continue
+        }
+        contexts = append(contexts, ctx)
+    }
+    return contexts, errs, nil
+}
+
+// writeContextCSVs writes one <Lot>_context.csv per lot, in lot order,
+// with the cartridges sorted by SN.
+func writeContextCSVs(outputDir string, contexts []details.ContextResult) ([]string, error) {
+    lotRows := make(map[string][]map[string]string)
+    for _, ctx := range contexts {
+        lotRows[ctx.Lot] = append(lotRows[ctx.Lot], ctx.Row())
+    }
+    lots := make([]string, 0, len(lotRows))
+    for lot := range lotRows {
+        lots = append(lots, lot)
+    }
+    sort.Strings(lots)
+
+    var outputs []string
+    for _, lot := range lots {
+        rows := lotRows[lot]
+        sort.SliceStable(rows, func(i, j int) bool {
+            return barcode.CompareSN(rows[i]["SN"], rows[j]["SN"]) < 0
+        })
+
+        outputFilePath := filepath.Join(outputDir, fmt.Sprintf("%s_context.csv", lot))
+        if err := csvout.Write(outputFilePath, rows); err != nil {
+            return outputs, fmt.Errorf("writing context CSV file: %w", err)
+        }
+        outputs = append(outputs, outputFilePath)
+    }
+    return outputs, nil
+}